@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"monks.co/backupd/auth"
+)
+
+// readOnlyPaths are POSTed to, but change nothing, so readers may use them.
+var readOnlyPaths = []string{apiPrefix + "policy/diff"}
+
+// protect wraps the server's handler so that requests must authenticate, if
+// the config has users or a client CA, and only operators may change state.
+func (b *Backupd) protect(handler http.Handler) (http.Handler, error) {
+	authn, err := auth.New(b.config)
+	if err != nil {
+		return nil, err
+	}
+	if !authn.Enabled() {
+		b.globalLogs.Printf("warning: no http users or client_ca are configured; anyone who can reach %s may control backupd", b.addr)
+	}
+	return authn.Handler(handler, readOnlyPaths, b.audit), nil
+}
+
+// audit records a request that may change state, in the global log and in
+// the audit log.
+func (b *Backupd) audit(rec auth.Record) {
+	user := rec.User
+	if user == "" {
+		user = "unauthenticated client"
+	}
+	b.globalLogs.Printf("audit: %s %s from %s by %s: %s (%d)", rec.Method, rec.URL, rec.Remote, user, rec.Outcome, rec.Status)
+	if b.auditLog == nil {
+		return
+	}
+	if err := b.auditLog.Write(rec); err != nil {
+		b.globalLogs.Printf("%v", err)
+	}
+}
+
+// callDaemon makes a request of the running daemon, from a command, with
+// the first operator's credentials from the config.
+func (b *Backupd) callDaemon(ctx context.Context, timeout time.Duration, method, path string, body io.Reader) (*http.Response, error) {
+	tlsConfig, err := auth.ClientTLS(b.config)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	client := &http.Client{Timeout: timeout}
+	if tlsConfig != nil {
+		scheme = "https"
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s://%s%s", scheme, b.addr, path), body)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if err := auth.SetCredentials(req, b.config); err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
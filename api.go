@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+)
+
+// apiPrefix is where the versioned JSON API is served. Fields may be added
+// within a version, but not renamed or removed.
+const apiPrefix = "/api/v1/"
+
+// staleAfter is how far the remote may fall behind the local side before a
+// dataset counts as stale, here and in the web UI.
+const staleAfter = 10 * time.Minute
+
+// apiDataset is a dataset as listed at /api/v1/datasets.
+type apiDataset struct {
+	Name             string   `json:"name"`
+	Status           string   `json:"status"`
+	StalenessSeconds float64  `json:"staleness_seconds"`
+	Local            apiSide  `json:"local"`
+	Remote           apiSide  `json:"remote"`
+	Plan             *apiPlan `json:"plan,omitempty"`
+}
+
+// apiSide is one location's snapshots and storage metrics for a dataset.
+// Sizes are omitted when they aren't known, e.g. for a dataset that doesn't
+// exist on the remote yet.
+type apiSide struct {
+	Snapshots              int        `json:"snapshots"`
+	Newest                 string     `json:"newest,omitempty"`
+	NewestAt               *time.Time `json:"newest_at,omitempty"`
+	UsedBytes              *int64     `json:"used_bytes,omitempty"`
+	LogicalReferencedBytes *int64     `json:"logical_referenced_bytes,omitempty"`
+}
+
+// apiDatasetDetail is a dataset as served at /api/v1/dataset: its summary,
+// with its snapshots now and as its goal would have them.
+type apiDatasetDetail struct {
+	apiDataset
+	Snapshots       apiInventory  `json:"snapshots"`
+	TargetSnapshots *apiInventory `json:"target_snapshots,omitempty"`
+}
+
+type apiInventory struct {
+	Local  []apiSnapshot `json:"local"`
+	Remote []apiSnapshot `json:"remote"`
+}
+
+type apiSnapshot struct {
+	Name                   string    `json:"name"`
+	Type                   string    `json:"type"`
+	CreatedAt              time.Time `json:"created_at"`
+	LogicalReferencedBytes int64     `json:"logical_referenced_bytes,omitempty"`
+	Pinned                 bool      `json:"pinned,omitempty"`
+}
+
+type apiPlan struct {
+	Fingerprint        string    `json:"fingerprint"`
+	NeedsApproval      string    `json:"needs_approval,omitempty"`
+	HoldsOnlyDeletions bool      `json:"holds_only_deletions,omitempty"`
+	HeldBack           string    `json:"held_back,omitempty"`
+	Approved           bool      `json:"approved,omitempty"`
+	Steps              []apiStep `json:"steps"`
+}
+
+type apiStep struct {
+	Index           int          `json:"index"`
+	Operation       string       `json:"operation"`
+	Kind            string       `json:"kind"`
+	Status          string       `json:"status"`
+	StartedAt       *time.Time   `json:"started_at,omitempty"`
+	StoppedAt       *time.Time   `json:"stopped_at,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds,omitempty"`
+	Progress        *apiProgress `json:"progress,omitempty"`
+	Checksum        string       `json:"checksum,omitempty"`
+}
+
+type apiProgress struct {
+	BytesDone   int64     `json:"bytes_done"`
+	TotalBytes  int64     `json:"total_bytes,omitempty"`
+	Percent     float64   `json:"percent,omitempty"`
+	CurrentRate float64   `json:"current_rate,omitempty"`
+	AverageRate float64   `json:"average_rate,omitempty"`
+	ETASeconds  float64   `json:"eta_seconds,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// apiStatus is what the sync loop is doing, as served at /api/v1/status.
+type apiStatus struct {
+	DryRun   bool       `json:"dryrun"`
+	Syncing  []string   `json:"syncing"`
+	Held     []string   `json:"held"`
+	Stale    []string   `json:"stale"`
+	Target   *apiTarget `json:"target,omitempty"`
+	Warnings []string   `json:"warnings"`
+}
+
+// apiTarget is a removable target, if one is configured.
+type apiTarget struct {
+	Pool    string           `json:"pool"`
+	Present bool             `json:"present"`
+	Current string           `json:"current,omitempty"`
+	Drives  []apiTargetDrive `json:"drives"`
+}
+
+type apiTargetDrive struct {
+	GUID       string     `json:"guid"`
+	Name       string     `json:"name"`
+	LastSeen   time.Time  `json:"last_seen"`
+	LastSynced *time.Time `json:"last_synced,omitempty"`
+}
+
+// datasetStatus sums up a dataset the way the web UI's indicator does:
+// syncing, held, stale, or synced.
+func (b *Backupd) datasetStatus(name model.DatasetName, ds *model.Dataset) string {
+	switch {
+	case b.syncStatus.IsSyncing(name):
+		return "syncing"
+	case ds.Plan.Held():
+		return "held"
+	case ds.Staleness() > staleAfter:
+		return "stale"
+	default:
+		return "synced"
+	}
+}
+
+func (b *Backupd) apiDataset(name model.DatasetName, ds *model.Dataset) apiDataset {
+	out := apiDataset{
+		Name:             name.String(),
+		Status:           b.datasetStatus(name, ds),
+		StalenessSeconds: ds.Staleness().Seconds(),
+		Plan:             newAPIPlan(ds.Plan),
+	}
+	if ds.Current != nil {
+		out.Local = newAPISide(ds.Current.Local)
+		out.Remote = newAPISide(ds.Current.Remote)
+	}
+	if ds.Metrics.HasLocal {
+		out.Local.UsedBytes = &ds.Metrics.LocalSize.Used
+		out.Local.LogicalReferencedBytes = &ds.Metrics.LocalSize.LogicalReferenced
+	}
+	if ds.Metrics.HasRemote {
+		out.Remote.UsedBytes = &ds.Metrics.RemoteSize.Used
+		out.Remote.LogicalReferencedBytes = &ds.Metrics.RemoteSize.LogicalReferenced
+	}
+	return out
+}
+
+func newAPISide(snaps *model.Snapshots) apiSide {
+	side := apiSide{Snapshots: snaps.Len()}
+	if newest := snaps.Newest(); newest != nil {
+		at := newest.Time()
+		side.Newest, side.NewestAt = newest.Name, &at
+	}
+	return side
+}
+
+func newAPIInventory(inv *model.SnapshotInventory) apiInventory {
+	out := apiInventory{Local: []apiSnapshot{}, Remote: []apiSnapshot{}}
+	if inv == nil {
+		return out
+	}
+	list := func(snaps *model.Snapshots) []apiSnapshot {
+		list := []apiSnapshot{}
+		for snap := range snaps.All() {
+			list = append(list, apiSnapshot{
+				Name:                   snap.Name,
+				Type:                   snap.Type(),
+				CreatedAt:              snap.Time(),
+				LogicalReferencedBytes: snap.LogicalReferenced,
+				Pinned:                 inv.Pinned(snap),
+			})
+		}
+		return list
+	}
+	out.Local, out.Remote = list(inv.Local), list(inv.Remote)
+	return out
+}
+
+func newAPIPlan(plan *model.Plan) *apiPlan {
+	if plan == nil {
+		return nil
+	}
+	out := &apiPlan{
+		Fingerprint:        plan.Fingerprint(),
+		NeedsApproval:      plan.NeedsApproval,
+		HoldsOnlyDeletions: plan.HoldsOnlyDeletions,
+		HeldBack:           plan.HeldBack,
+		Approved:           plan.Approved,
+		Steps:              []apiStep{},
+	}
+	for i, step := range plan.Steps {
+		out.Steps = append(out.Steps, newAPIStep(i, step))
+	}
+	return out
+}
+
+func newAPIStep(index int, step *model.PlanStep) apiStep {
+	out := apiStep{
+		Index:           index,
+		Operation:       step.String(),
+		Kind:            model.OperationKind(step),
+		Status:          step.Status.String(),
+		StartedAt:       step.StartedAt,
+		StoppedAt:       step.StoppedAt,
+		DurationSeconds: step.Duration().Seconds(),
+		Checksum:        step.Checksum,
+	}
+	if p := step.Progress; p != nil {
+		out.Progress = &apiProgress{
+			BytesDone:   p.BytesDone,
+			TotalBytes:  p.TotalBytes,
+			Percent:     p.Percent(),
+			CurrentRate: p.CurrentRate,
+			AverageRate: p.AverageRate,
+			ETASeconds:  p.ETA.Seconds(),
+			UpdatedAt:   p.UpdatedAt,
+		}
+	}
+	return out
+}
+
+func newAPITarget(target *model.Target) *apiTarget {
+	if target == nil {
+		return nil
+	}
+	out := &apiTarget{
+		Pool:    target.Pool,
+		Present: target.Present,
+		Current: target.Current,
+		Drives:  []apiTargetDrive{},
+	}
+	for _, drive := range target.ListDrives() {
+		d := apiTargetDrive{GUID: drive.GUID, Name: drive.Name, LastSeen: drive.LastSeen}
+		if !drive.LastSynced.IsZero() {
+			d.LastSynced = &drive.LastSynced
+		}
+		out.Drives = append(out.Drives, d)
+	}
+	return out
+}
+
+// serveAPI routes the versioned JSON API.
+func (b *Backupd) serveAPI(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := b.state.Deref()
+	if state == nil {
+		http.Error(w, "Not ready", http.StatusServiceUnavailable)
+		return
+	}
+	query := req.URL.Query()
+
+	var body any
+	switch req.URL.Path[len(apiPrefix):] {
+	case "datasets":
+		datasets := []apiDataset{}
+		for _, name := range state.ListDatasets() {
+			datasets = append(datasets, b.apiDataset(name, state.GetDataset(name)))
+		}
+		body = datasets
+
+	case "dataset":
+		if !query.Has("dataset") {
+			http.Error(w, "Missing dataset parameter", http.StatusBadRequest)
+			return
+		}
+		name := parseDatasetName(query.Get("dataset"))
+		ds := state.GetDataset(name)
+		if ds == nil {
+			http.Error(w, "No such dataset", http.StatusNotFound)
+			return
+		}
+		detail := apiDatasetDetail{
+			apiDataset: b.apiDataset(name, ds),
+			Snapshots:  newAPIInventory(ds.Current),
+		}
+		if ds.Target != nil {
+			target := newAPIInventory(ds.Target)
+			detail.TargetSnapshots = &target
+		}
+		body = detail
+
+	case "logs":
+		logs := b.globalLogs
+		if query.Has("dataset") {
+			ds := state.GetDataset(parseDatasetName(query.Get("dataset")))
+			if ds == nil {
+				http.Error(w, "No such dataset", http.StatusNotFound)
+				return
+			}
+			logs = ds.Logs
+		}
+		entries := []logger.LogEntry{}
+		if logs != nil {
+			entries = append(entries, logs.GetLogs()...)
+		}
+		if query.Has("limit") {
+			n, err := strconv.Atoi(query.Get("limit"))
+			if err != nil || n < 1 {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			entries = entries[max(0, len(entries)-n):]
+		}
+		body = entries
+
+	case "status":
+		status := apiStatus{
+			DryRun:   b.dryrun,
+			Syncing:  []string{},
+			Held:     []string{},
+			Stale:    []string{},
+			Target:   newAPITarget(state.Target),
+			Warnings: append([]string{}, state.Warnings...),
+		}
+		for _, name := range state.ListDatasets() {
+			switch b.datasetStatus(name, state.GetDataset(name)) {
+			case "syncing":
+				status.Syncing = append(status.Syncing, name.String())
+			case "held":
+				status.Held = append(status.Held, name.String())
+			case "stale":
+				status.Stale = append(status.Stale, name.String())
+			}
+		}
+		body = status
+
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		b.globalLogs.Printf("writing %s: %v", req.URL.Path, err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"monks.co/backupd/config"
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+)
+
+// testAPI returns a daemon whose state holds one dataset, /home, which is
+// a day behind on the remote and has a held plan to catch up.
+func testAPI(t *testing.T) *Backupd {
+	t.Helper()
+	b := New(&config.Config{}, "", false)
+
+	now := time.Now()
+	older := &model.Snapshot{Name: "daily-1", CreatedAt: now.Add(-24 * time.Hour).Unix()}
+	newer := &model.Snapshot{Name: "daily-2", CreatedAt: now.Unix()}
+	plan := model.PlanFromOperations([]model.Operation{&model.SnapshotRangeTransfer{Start: older, End: newer}})
+	plan.NeedsApproval = "testing"
+	ds := &model.Dataset{
+		Name:    "/home",
+		Current: model.NewSnapshotInventory(model.NewSnapshots(older, newer), model.NewSnapshots(older)),
+		Plan:    plan,
+		Logs:    logger.New("/home"),
+	}
+	ds.Logs.Printf("first")
+	ds.Logs.Printf("second")
+	b.state.Reset(model.ReplaceDataset("/home", ds)(model.AddWarnings("check /home")(nil)))
+	return b
+}
+
+func getAPI(t *testing.T, b *Backupd, path string, body any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	b.serveAPI(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code == http.StatusOK && body != nil {
+		if err := json.NewDecoder(w.Body).Decode(body); err != nil {
+			t.Fatalf("%s: decoding response: %v", path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestAPIDatasets(t *testing.T) {
+	b := testAPI(t)
+
+	var datasets []apiDataset
+	if code := getAPI(t, b, "/api/v1/datasets", &datasets); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(datasets) != 1 {
+		t.Fatalf("expected one dataset, got %+v", datasets)
+	}
+	home := datasets[0]
+	if home.Name != "/home" || home.Status != "held" || home.Local.Snapshots != 2 || home.Remote.Newest != "daily-1" {
+		t.Errorf("unexpected dataset %+v", home)
+	}
+	if home.Plan == nil || len(home.Plan.Steps) != 1 || home.Plan.NeedsApproval != "testing" {
+		t.Errorf("unexpected plan %+v", home.Plan)
+	}
+}
+
+func TestAPIDataset(t *testing.T) {
+	b := testAPI(t)
+
+	var detail apiDatasetDetail
+	if code := getAPI(t, b, "/api/v1/dataset?dataset=/home", &detail); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(detail.Snapshots.Local) != 2 || len(detail.Snapshots.Remote) != 1 {
+		t.Errorf("unexpected snapshots %+v", detail.Snapshots)
+	}
+
+	for path, want := range map[string]int{
+		"/api/v1/dataset":               http.StatusBadRequest,
+		"/api/v1/dataset?dataset=/nope": http.StatusNotFound,
+	} {
+		if code := getAPI(t, b, path, nil); code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, code)
+		}
+	}
+}
+
+func TestAPILogs(t *testing.T) {
+	b := testAPI(t)
+
+	var entries []logger.LogEntry
+	if code := getAPI(t, b, "/api/v1/logs?dataset=/home&limit=1", &entries); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(entries) != 1 || entries[0].Log != "second" {
+		t.Errorf("expected only the newest entry, got %+v", entries)
+	}
+
+	for path, want := range map[string]int{
+		"/api/v1/logs?limit=0":       http.StatusBadRequest,
+		"/api/v1/logs?limit=many":    http.StatusBadRequest,
+		"/api/v1/logs?dataset=/nope": http.StatusNotFound,
+		"/api/v1/nothing":            http.StatusNotFound,
+	} {
+		if code := getAPI(t, b, path, nil); code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, code)
+		}
+	}
+}
+
+func TestAPIStatus(t *testing.T) {
+	b := testAPI(t)
+
+	var status apiStatus
+	if code := getAPI(t, b, "/api/v1/status", &status); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(status.Held) != 1 || status.Held[0] != "/home" || len(status.Syncing) != 0 {
+		t.Errorf("expected /home to be held, got %+v", status)
+	}
+	if len(status.Warnings) != 1 || status.Warnings[0] != "check /home" {
+		t.Errorf("expected the warning, got %+v", status.Warnings)
+	}
+
+	w := httptest.NewRecorder()
+	b.serveAPI(w, httptest.NewRequest(http.MethodPost, "/api/v1/status", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be refused, got %d", w.Code)
+	}
+}
+
+func TestPolicyDiffLimits(t *testing.T) {
+	b := testAPI(t)
+
+	for name, tc := range map[string]struct {
+		body string
+		want int
+	}{
+		"too large": {strings.Repeat("#", maxProposedConfig+1), http.StatusRequestEntityTooLarge},
+		"invalid":   {"local = 1", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		b.servePolicyDiff(w, httptest.NewRequest(http.MethodPost, "/api/v1/policy/diff", strings.NewReader(tc.body)))
+		if w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", name, tc.want, w.Code)
+		}
+	}
+}
+
+func TestPolicyDiffBytes(t *testing.T) {
+	conf := &config.Config{}
+	conf.Local.Policy = map[string]int{"daily": 4}
+	conf.Remote.Policy = map[string]int{"daily": 4}
+	b := New(conf, "", false)
+
+	var snaps []*model.Snapshot
+	for i := range int64(4) {
+		snaps = append(snaps, &model.Snapshot{Dataset: "/home", Name: fmt.Sprintf("daily-%d", i), CreatedAt: i, Used: 10 << 20, Written: 100 << 20})
+	}
+	b.state.Reset(model.ReplaceDataset("/home", &model.Dataset{
+		Name:    "/home",
+		Current: model.NewSnapshotInventory(model.NewSnapshots(snaps...), model.NewSnapshots(snaps...)),
+		Logs:    logger.New("/home"),
+	})(nil))
+
+	proposed := &config.Config{}
+	proposed.Local.Policy = conf.Local.Policy
+	proposed.Remote.Policy = map[string]int{"daily": 1}
+	report := b.PolicyDiff(proposed)
+	if len(report.Datasets) != 1 {
+		t.Fatalf("expected one changed dataset, got %+v", report)
+	}
+	deleted := report.Datasets[0].NewlyDeleted.Remote
+	if deleted.Count != 2 || deleted.Bytes != 20<<20 {
+		t.Errorf("expected two remote snapshots using 20 MiB to be deleted, got %+v", deleted)
+	}
+	if !strings.Contains(report.String(), "remote: newly deleted: 2 (at least 21 MB freed)") {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"monks.co/backupd/model"
+)
+
+// errPlanHeld is returned by syncDataset when the dataset's plan is waiting
+// for approval.
+var errPlanHeld = errors.New("plan needs approval")
+
+// errDeletionsHeld is returned by syncDataset when the dataset's deletions
+// are waiting for approval, once the rest of its plan has run.
+var errDeletionsHeld = fmt.Errorf("%w: deletions held", errPlanHeld)
+
+// Approve approves the held plan of the given dataset, which must have the
+// given fingerprint, and wakes the sync loop to execute it.
+func (b *Backupd) Approve(dataset model.DatasetName, fingerprint string) error {
+	ds := b.state.Deref().GetDataset(dataset)
+	if ds == nil {
+		return fmt.Errorf("no such dataset '%s'", dataset)
+	}
+	if ds.Plan == nil || ds.Plan.NeedsApproval == "" {
+		return fmt.Errorf("dataset '%s' has no plan waiting for approval", dataset)
+	}
+	if got := ds.Plan.Fingerprint(); got != fingerprint {
+		return fmt.Errorf("dataset '%s' has plan %s, not %s; it may have changed since you looked", dataset, got, fingerprint)
+	}
+
+	b.approvals.Swap(func(old map[model.DatasetName]string) map[model.DatasetName]string {
+		out := maps.Clone(old)
+		out[dataset] = fingerprint
+		return out
+	})
+	b.saveApprovals()
+	b.state.Swap(func(state *model.Model) *model.Model {
+		currentDS := state.GetDataset(dataset)
+		if currentDS == nil || currentDS.Plan == nil {
+			return state
+		}
+		updatedDS := currentDS.Clone()
+		updatedDS.Plan.Approved = updatedDS.Plan.Fingerprint() == fingerprint
+		return model.ReplaceDataset(dataset, updatedDS)(state)
+	})
+	b.globalLogs.Printf("approved plan %s for '%s'", fingerprint, dataset)
+	ds.Logs.Printf("plan %s approved", fingerprint)
+	b.notifyDatasetChange(dataset)
+	b.wake()
+
+	return nil
+}
+
+func (b *Backupd) isApproved(dataset model.DatasetName, plan *model.Plan) bool {
+	fingerprint, ok := b.approvals.Deref()[dataset]
+	return ok && fingerprint == plan.Fingerprint()
+}
+
+// consumeApproval forgets the approval for a dataset once its plan has run,
+// so that it can't be reused.
+func (b *Backupd) consumeApproval(dataset model.DatasetName) {
+	b.approvals.Swap(func(old map[model.DatasetName]string) map[model.DatasetName]string {
+		out := maps.Clone(old)
+		delete(out, dataset)
+		return out
+	})
+	b.saveApprovals()
+}
+
+// saveApprovals records the outstanding approvals, so that a restart before
+// an approved plan runs doesn't lose them.
+func (b *Backupd) saveApprovals() {
+	if b.history == nil {
+		return
+	}
+	if err := b.history.SaveApprovals(b.approvals.Deref()); err != nil {
+		b.globalLogs.Printf("%v", err)
+	}
+}
+
+// loadApprovals restores the approvals saved by a previous process. They
+// still only apply to plans with the approved fingerprint.
+func (b *Backupd) loadApprovals() error {
+	approvals, err := b.history.LoadApprovals()
+	if err != nil {
+		return err
+	}
+	b.approvals.Reset(approvals)
+	return nil
+}
+
+// wake cuts short the sync loop's wait between cycles.
+func (b *Backupd) wake() {
+	select {
+	case b.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// RequestApproval asks the running daemon to approve the given dataset's
+// held plan. Without a fingerprint, it prints the held plan instead, so it
+// can be reviewed and then approved by fingerprint.
+func (b *Backupd) RequestApproval(ctx context.Context, dataset, fingerprint string) error {
+	method := http.MethodPost
+	if fingerprint == "" {
+		method = http.MethodGet
+	}
+	query := url.Values{}
+	query.Set("dataset", dataset)
+	query.Set("plan", fingerprint)
+	resp, err := b.callDaemon(ctx, 30*time.Second, method, "/approve?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("calling approve endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("approve endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if fingerprint == "" {
+		fmt.Print(string(body))
+		fmt.Printf("\nTo approve, run: backupd approve %s <plan>\n", dataset)
+		return nil
+	}
+	log.Printf("%s", strings.TrimSpace(string(body)))
+	return nil
+}
+
+// approveURL is where the UI posts approval of the given dataset's plan.
+func approveURL(dataset model.DatasetName, plan *model.Plan) templ.SafeURL {
+	query := url.Values{}
+	query.Set("dataset", dataset.String())
+	query.Set("plan", plan.Fingerprint())
+	return templ.SafeURL("/approve?" + query.Encode())
+}
@@ -0,0 +1,407 @@
+// Package auth authenticates requests to the web UI and API, with bearer
+// tokens, HTTP basic auth, or client certificates, and authorizes them by
+// role: readers may only look, and operators may also change things.
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"monks.co/backupd/config"
+)
+
+// A Role is what a user may do.
+type Role int
+
+const (
+	// Read may view the UI and API.
+	Read Role = iota + 1
+	// Operator may also make requests that change state, such as creating
+	// snapshots, approving plans, and restoring.
+	Operator
+)
+
+// ParseRole parses a role as written in the config.
+func ParseRole(s string) (Role, error) {
+	switch s {
+	case "read":
+		return Read, nil
+	case "operator":
+		return Operator, nil
+	}
+	return 0, fmt.Errorf("role must be \"read\" or \"operator\", not %q", s)
+}
+
+func (role Role) String() string {
+	switch role {
+	case Read:
+		return "read"
+	case Operator:
+		return "operator"
+	}
+	return "none"
+}
+
+// A Principal is who made a request.
+type Principal struct {
+	Name string
+	Role Role
+	// Method is how they authenticated: "token", "basic", "cert", or
+	// "none" if authentication is off.
+	Method string
+}
+
+type user struct {
+	name     string
+	role     Role
+	token    []byte // sha256 digests, so that compares take constant time
+	password []byte
+}
+
+// An Authenticator checks requests against the users and client CA in the
+// config.
+type Authenticator struct {
+	users      []user
+	clientCert bool
+	clientRole Role
+}
+
+// New reads the users' secrets. With no users and no client CA, every
+// request is let through as an operator.
+func New(conf *config.Config) (*Authenticator, error) {
+	a := &Authenticator{clientCert: conf.HTTP.ClientCA != "", clientRole: Read}
+	if conf.HTTP.ClientRole != "" {
+		role, err := ParseRole(conf.HTTP.ClientRole)
+		if err != nil {
+			return nil, fmt.Errorf("http.client_role: %w", err)
+		}
+		a.clientRole = role
+	}
+	for _, u := range conf.HTTP.Users {
+		role, err := ParseRole(u.Role)
+		if err != nil {
+			return nil, fmt.Errorf("http user '%s': %w", u.Name, err)
+		}
+		entry := user{name: u.Name, role: role}
+		if u.TokenFile != "" {
+			token, err := readSecret(u.TokenFile, minTokenLen)
+			if err != nil {
+				return nil, fmt.Errorf("http user '%s': %w", u.Name, err)
+			}
+			entry.token = digest(token)
+		}
+		if u.PasswordFile != "" {
+			password, err := readSecret(u.PasswordFile, minPasswordLen)
+			if err != nil {
+				return nil, fmt.Errorf("http user '%s': %w", u.Name, err)
+			}
+			entry.password = digest(password)
+		}
+		a.users = append(a.users, entry)
+	}
+	return a, nil
+}
+
+// Enabled reports whether requests must authenticate.
+func (a *Authenticator) Enabled() bool {
+	return len(a.users) > 0 || a.clientCert
+}
+
+const (
+	minTokenLen    = 16
+	minPasswordLen = 8
+)
+
+func readSecret(path string, minLen int) ([]byte, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret: %w", err)
+	}
+	secret := bytes.TrimSpace(bs)
+	if len(secret) < minLen {
+		return nil, fmt.Errorf("secret in '%s' is too short; use at least %d bytes", path, minLen)
+	}
+	return secret, nil
+}
+
+func digest(secret []byte) []byte {
+	sum := sha256.Sum256(secret)
+	return sum[:]
+}
+
+var (
+	errNoCredentials  = errors.New("no credentials")
+	errBadCredentials = errors.New("invalid credentials")
+)
+
+// Authenticate finds who made the request. Credentials in the
+// Authorization header take precedence over a client certificate.
+func (a *Authenticator) Authenticate(req *http.Request) (Principal, error) {
+	if !a.Enabled() {
+		return Principal{Name: "anonymous", Role: Operator, Method: "none"}, nil
+	}
+
+	if header := req.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			sum := digest([]byte(strings.TrimSpace(token)))
+			for _, u := range a.users {
+				if u.token != nil && subtle.ConstantTimeCompare(u.token, sum) == 1 {
+					return Principal{Name: u.name, Role: u.role, Method: "token"}, nil
+				}
+			}
+			return Principal{}, errBadCredentials
+		}
+		if name, password, ok := req.BasicAuth(); ok {
+			sum := digest([]byte(password))
+			for _, u := range a.users {
+				if u.name == name && u.password != nil && subtle.ConstantTimeCompare(u.password, sum) == 1 {
+					return Principal{Name: u.name, Role: u.role, Method: "basic"}, nil
+				}
+			}
+			return Principal{Name: name}, errBadCredentials
+		}
+		return Principal{}, errBadCredentials
+	}
+
+	// The TLS handshake has already verified any certificate against the
+	// client CA.
+	if a.clientCert && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return Principal{Name: req.TLS.VerifiedChains[0][0].Subject.CommonName, Role: a.clientRole, Method: "cert"}, nil
+	}
+	return Principal{}, errNoCredentials
+}
+
+// changesState reports whether the request may change state, and so needs
+// the operator role. Any method but GET, HEAD, or OPTIONS is taken to, so
+// that new endpoints are covered, unless its path is listed as read-only.
+func changesState(req *http.Request, readOnly []string) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return !slices.Contains(readOnly, req.URL.Path)
+}
+
+// A Record is an audited request.
+type Record struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"`
+	Role    string    `json:"role,omitempty"`
+	Auth    string    `json:"auth,omitempty"`
+	Remote  string    `json:"remote"`
+	Method  string    `json:"method"`
+	URL     string    `json:"url"`
+	Outcome string    `json:"outcome"` // "denied", or "allowed" with Status
+	Status  int       `json:"status"`
+}
+
+// An AuditLog is an append-only file of records, one JSON object per line.
+type AuditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenAuditLog opens the audit log at path, creating it if need be.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &AuditLog{f: f}, nil
+}
+
+// Write appends the record to the log.
+func (log *AuditLog) Write(rec Record) error {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if _, err := log.f.Write(append(bs, '\n')); err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log's file.
+func (log *AuditLog) Close() error {
+	return log.f.Close()
+}
+
+// Handler authenticates every request to next, refusing those without valid
+// credentials, and lets through state-changing ones only from operators.
+// Cross-origin requests that change state are refused, so that a browser
+// signed in to the UI can't be made to by another site. Every
+// state-changing request, whether let through or not, is passed to audit
+// once it's done. Paths in readOnly are served to readers whatever the
+// method.
+func (a *Authenticator) Handler(next http.Handler, readOnly []string, audit func(Record)) http.Handler {
+	crossOrigin := http.NewCrossOriginProtection()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		changes := changesState(req, readOnly)
+		record := func(principal Principal, outcome string, status int) {
+			if !changes {
+				return
+			}
+			rec := Record{
+				Time:    time.Now(),
+				User:    principal.Name,
+				Auth:    principal.Method,
+				Remote:  req.RemoteAddr,
+				Method:  req.Method,
+				URL:     req.URL.RequestURI(),
+				Outcome: outcome,
+				Status:  status,
+			}
+			if principal.Role != 0 {
+				rec.Role = principal.Role.String()
+			}
+			audit(rec)
+		}
+
+		principal, err := a.Authenticate(req)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="backupd", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			record(principal, "denied", http.StatusUnauthorized)
+			return
+		}
+		if !changes {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if principal.Role < Operator {
+			http.Error(w, "Forbidden: this requires the operator role", http.StatusForbidden)
+			record(principal, "denied", http.StatusForbidden)
+			return
+		}
+		if err := crossOrigin.Check(req); err != nil {
+			http.Error(w, "Forbidden: cross-origin request", http.StatusForbidden)
+			record(principal, "denied", http.StatusForbidden)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, req)
+		record(principal, "allowed", sw.status)
+	})
+}
+
+// statusWriter remembers the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(bs []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(bs)
+}
+
+// ServerTLS returns the TLS config for serving the UI and API, or nil if
+// it's served over plain HTTP. With a client CA, clients may present a
+// certificate it signed in place of other credentials.
+func ServerTLS(conf *config.Config) (*tls.Config, error) {
+	if conf.HTTP.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(conf.HTTP.TLSCert, conf.HTTP.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.HTTP.ClientCA != "" {
+		bs, err := os.ReadFile(conf.HTTP.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("no certificates in '%s'", conf.HTTP.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// ClientTLS returns the TLS config for the command line to reach the
+// daemon on the same machine, or nil if it serves plain HTTP. The daemon
+// is trusted if it presents exactly the configured certificate, whatever
+// name it's reached by.
+func ClientTLS(conf *config.Config) (*tls.Config, error) {
+	if conf.HTTP.TLSCert == "" {
+		return nil, nil
+	}
+	bs, err := os.ReadFile(conf.HTTP.TLSCert)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate in '%s'", conf.HTTP.TLSCert)
+	}
+	return &tls.Config{
+		// Verified by pinning below instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], block.Bytes) {
+				return errors.New("server did not present the configured http.tls_cert")
+			}
+			return nil
+		},
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// SetCredentials adds the first operator's credentials from the config to
+// a request from the command line, preferring a token. It does nothing if
+// there are no operators with credentials.
+func SetCredentials(req *http.Request, conf *config.Config) error {
+	for _, u := range conf.HTTP.Users {
+		if u.Role != "operator" {
+			continue
+		}
+		if u.TokenFile != "" {
+			token, err := readSecret(u.TokenFile, minTokenLen)
+			if err != nil {
+				return fmt.Errorf("http user '%s': %w", u.Name, err)
+			}
+			req.Header.Set("Authorization", "Bearer "+string(token))
+			return nil
+		}
+		if u.PasswordFile != "" {
+			password, err := readSecret(u.PasswordFile, minPasswordLen)
+			if err != nil {
+				return fmt.Errorf("http user '%s': %w", u.Name, err)
+			}
+			req.SetBasicAuth(u.Name, string(password))
+			return nil
+		}
+	}
+	return nil
+}
@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/a-h/templ"
 	"golang.org/x/sync/errgroup"
 	"monks.co/backupd/atom"
+	"monks.co/backupd/auth"
 	"monks.co/backupd/config"
 	"monks.co/backupd/env"
+	"monks.co/backupd/events"
+	"monks.co/backupd/history"
+	"monks.co/backupd/journal"
 	"monks.co/backupd/logger"
 	"monks.co/backupd/model"
 	"monks.co/backupd/snitch"
@@ -29,8 +36,23 @@ type Backupd struct {
 	env        *env.Env
 	addr       string
 	dryrun     bool
-	version    *atom.Atom[int64]
-	versionCh  chan struct{}
+	events     *events.Broker
+	published  published
+	approvals  *atom.Atom[map[model.DatasetName]string]
+	lastSynced *atom.Atom[map[model.DatasetName]time.Time]
+	wakeCh     chan struct{}
+
+	// history records sync cycles. It's opened by Go, so it's nil for
+	// one-off commands.
+	history *history.Store
+
+	// journal records zfs operations before they're applied. It's
+	// opened by Go, so it's nil for one-off commands.
+	journal *journal.Journal
+
+	// auditLog records requests that may change state. It's opened by
+	// Go, so it's nil for one-off commands.
+	auditLog *auth.AuditLog
 }
 
 func New(config *config.Config, addr string, dryrun bool) *Backupd {
@@ -42,17 +64,29 @@ func New(config *config.Config, addr string, dryrun bool) *Backupd {
 		env:        env.New(config),
 		addr:       addr,
 		dryrun:     dryrun,
-		version:    atom.New[int64](0),
-		versionCh:  make(chan struct{}, 1),
+		events:     events.New(),
+		approvals:  atom.New(map[model.DatasetName]string{}),
+		lastSynced: atom.New(map[model.DatasetName]time.Time{}),
+		wakeCh:     make(chan struct{}, 1),
 	}
 }
 
+// notifyStateChange tells subscribers to the event stream if anything but
+// the datasets changed since it was last called.
 func (b *Backupd) notifyStateChange() {
-	b.version.Swap(func(v int64) int64 { return v + 1 })
-	select {
-	case b.versionCh <- struct{}{}:
-	default:
-	}
+	b.publishState()
+}
+
+// notifyDatasetChange tells subscribers to the event stream that the
+// dataset changed.
+func (b *Backupd) notifyDatasetChange(dataset model.DatasetName) {
+	b.publishDataset(dataset)
+}
+
+// notifyDatasetsChange tells subscribers to the event stream that any of
+// the datasets may have changed, or been added or removed.
+func (b *Backupd) notifyDatasetsChange() {
+	b.publishDatasets()
 }
 
 // updateStep updates a plan step in a thread-safe manner
@@ -65,10 +99,42 @@ func (b *Backupd) updateStep(dataset model.DatasetName, stepIndex int, update fu
 		update(currentDS.Plan.Steps[stepIndex])
 		return model.ReplaceDataset(dataset, currentDS)(state)
 	})
-	b.notifyStateChange()
+	b.publishStep(dataset, stepIndex)
 }
 
 func (b *Backupd) Go(ctx context.Context) error {
+	store, err := history.Open(filepath.Join(b.config.StateDir, "history"))
+	if err != nil {
+		return fmt.Errorf("opening history: %w", err)
+	}
+	b.history = store
+	if err := b.loadLastSynced(); err != nil {
+		return fmt.Errorf("reading history: %w", err)
+	}
+	if err := b.loadTarget(); err != nil {
+		return err
+	}
+	if err := b.loadWarnings(); err != nil {
+		return err
+	}
+	if err := b.loadApprovals(); err != nil {
+		return err
+	}
+
+	wal, err := journal.Open(filepath.Join(b.config.StateDir, "journal.jsonl"))
+	if err != nil {
+		return fmt.Errorf("opening journal: %w", err)
+	}
+	defer wal.Close()
+	b.journal = wal
+
+	auditLog, err := auth.OpenAuditLog(filepath.Join(b.config.StateDir, "audit.jsonl"))
+	if err != nil {
+		return err
+	}
+	defer auditLog.Close()
+	b.auditLog = auditLog
+
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
@@ -98,6 +164,11 @@ func (b *Backupd) Serve(ctx context.Context) error {
 			return
 		}
 
+		if b.config.Local.Replica {
+			http.Error(w, "Local is a replica; snapshots come from upstream", http.StatusConflict)
+			return
+		}
+
 		root := b.config.Local.Root
 
 		if err := b.env.CreateSnapshotRecursively(ctx, b.globalLogs, root, periodicity); err != nil {
@@ -111,29 +182,153 @@ func (b *Backupd) Serve(ctx context.Context) error {
 		}
 
 		b.globalLogs.Printf("Created %s snapshot for root %s", periodicity, root)
-		b.notifyStateChange()
+		b.notifyDatasetsChange()
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "Created %s snapshot for root %s\n", periodicity, root)
 	})
 
-	// Long-polling endpoint for state changes
-	mux.HandleFunc("/poll", func(w http.ResponseWriter, req *http.Request) {
-		if req.Method != http.MethodGet {
+	// Handle restore requests. The restore runs in the background; its
+	// progress is shown at /restores.
+	mux.HandleFunc("/restore", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		select {
-		case <-b.versionCh:
+		query := req.URL.Query()
+		if !query.Has("dataset") {
+			http.Error(w, "Missing dataset parameter", http.StatusBadRequest)
+			return
+		}
+		dataset := parseDatasetName(query.Get("dataset"))
+		to := dataset
+		if query.Get("to") != "" {
+			to = parseDatasetName(query.Get("to"))
+		}
+
+		restore, err := b.Restore(ctx, dataset, query.Get("snapshot"), to)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error starting restore: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Started restore #%d: %s\n", restore.ID, restore)
+	})
+
+	// Handle approval of held plans. GET describes the dataset's held
+	// plan, so that it can be reviewed before approving it.
+	mux.HandleFunc("/approve", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			ds := b.state.Deref().GetDataset(parseDatasetName(req.URL.Query().Get("dataset")))
+			if ds == nil || ds.Plan == nil || ds.Plan.NeedsApproval == "" {
+				http.Error(w, "No plan waiting for approval", http.StatusNotFound)
+				return
+			}
 			w.WriteHeader(http.StatusOK)
-			fmt.Fprint(w, "refresh")
-		case <-time.After(5 * time.Minute):
-			w.WriteHeader(http.StatusNoContent)
-		case <-req.Context().Done():
-			w.WriteHeader(http.StatusNoContent)
+			fmt.Fprintf(w, "plan %s\n", ds.Plan.Fingerprint())
+			fmt.Fprintf(w, "needs approval: %s\n", ds.Plan.NeedsApproval)
+			for _, step := range ds.Plan.Steps {
+				fmt.Fprintf(w, "- %s\n", step)
+			}
+			return
+		}
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+		if !query.Has("dataset") || query.Get("plan") == "" {
+			http.Error(w, "Missing dataset or plan parameter", http.StatusBadRequest)
+			return
+		}
+		dataset := parseDatasetName(query.Get("dataset"))
+
+		if err := b.Approve(dataset, query.Get("plan")); err != nil {
+			http.Error(w, fmt.Sprintf("Error approving plan: %v", err), http.StatusConflict)
+			return
+		}
+
+		// Send browsers back to the page they approved from
+		if referer := req.Referer(); referer != "" {
+			http.Redirect(w, req, referer, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Approved plan %s for %s\n", query.Get("plan"), dataset)
+	})
+
+	// Handle pinning and unpinning snapshots
+	mux.HandleFunc("/pin", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+		if !query.Has("dataset") || query.Get("snapshot") == "" {
+			http.Error(w, "Missing dataset or snapshot parameter", http.StatusBadRequest)
+			return
+		}
+		dataset := parseDatasetName(query.Get("dataset"))
+		pinned := query.Get("pin") != "off"
+
+		if err := b.Pin(ctx, dataset, query.Get("snapshot"), pinned); err != nil {
+			http.Error(w, fmt.Sprintf("Error pinning snapshot: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		// Send browsers back to the page they pinned from
+		if referer := req.Referer(); referer != "" {
+			http.Redirect(w, req, referer, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if pinned {
+			fmt.Fprintf(w, "Pinned %s@%s\n", dataset, query.Get("snapshot"))
+		} else {
+			fmt.Fprintf(w, "Unpinned %s@%s\n", dataset, query.Get("snapshot"))
 		}
 	})
 
+	// Handle dismissing warnings, once they've been looked into
+	mux.HandleFunc("/warnings/dismiss", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		b.setWarnings(model.ClearWarnings)
+		b.globalLogs.Printf("warnings dismissed")
+
+		// Send browsers back to the page they dismissed from
+		if referer := req.Referer(); referer != "" {
+			http.Redirect(w, req, referer, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Dismissed warnings")
+	})
+
+	// Explain why each of a dataset's snapshots is kept or dropped
+	mux.HandleFunc(apiPrefix+"retention", b.serveRetention)
+
+	// Preview what a proposed config would change
+	mux.HandleFunc(apiPrefix+"policy/diff", b.servePolicyDiff)
+
+	// Recorded sync cycles, as JSON
+	mux.HandleFunc(apiPrefix+"cycles", b.serveCycles)
+
+	// Datasets, plans, logs, and sync status, as JSON
+	mux.HandleFunc(apiPrefix, b.serveAPI)
+
+	// Prometheus metrics
+	mux.Handle("/metrics", b.metricsHandler())
+
+	// Server-Sent Events stream of state changes
+	mux.HandleFunc("/events", b.serveEvents)
+	go b.publishLogs(ctx)
+
 	// Handle all routes with the generic handler and implement our own routing logic
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		// Only handle GET requests
@@ -159,7 +354,13 @@ func (b *Backupd) Serve(ctx context.Context) error {
 
 		// Handle special cases first
 		if trimmedPath == "global" {
-			templ.Handler(index(state, globalLogs, syncStatus, "global", b.dryrun)).ServeHTTP(w, req)
+			templ.Handler(index(state, globalLogs, syncStatus, "global", b.dryrun, nil)).ServeHTTP(w, req)
+			return
+		} else if trimmedPath == "restores" {
+			templ.Handler(index(state, globalLogs, syncStatus, "restores", b.dryrun, nil)).ServeHTTP(w, req)
+			return
+		} else if trimmedPath == "history" {
+			templ.Handler(index(state, globalLogs, syncStatus, "history", b.dryrun, b.loadHistory(req))).ServeHTTP(w, req)
 			return
 		} else if trimmedPath == "root" {
 			// The empty string is used as the dataset name for the root dataset
@@ -169,7 +370,7 @@ func (b *Backupd) Serve(ctx context.Context) error {
 				http.Error(w, "Root dataset not found", http.StatusNotFound)
 				return
 			}
-			templ.Handler(index(state, globalLogs, syncStatus, "", b.dryrun)).ServeHTTP(w, req)
+			templ.Handler(index(state, globalLogs, syncStatus, "", b.dryrun, nil)).ServeHTTP(w, req)
 			return
 		}
 
@@ -177,26 +378,62 @@ func (b *Backupd) Serve(ctx context.Context) error {
 		// Add leading slash for the dataset model
 		datasetForModel := "/" + trimmedPath
 
-		templ.Handler(index(state, globalLogs, syncStatus, datasetForModel, b.dryrun)).ServeHTTP(w, req)
+		templ.Handler(index(state, globalLogs, syncStatus, datasetForModel, b.dryrun, nil)).ServeHTTP(w, req)
 	})
 
-	return listenAndServe(ctx, b.addr, mux)
+	handler, err := b.protect(mux)
+	if err != nil {
+		return fmt.Errorf("configuring http auth: %w", err)
+	}
+	tlsConfig, err := auth.ServerTLS(b.config)
+	if err != nil {
+		return fmt.Errorf("configuring http tls: %w", err)
+	}
+	return listenAndServe(ctx, b.addr, handler, tlsConfig)
 }
 
 func (b *Backupd) Sync(ctx context.Context) error {
 	for {
+		cycle, logsFrom := history.NewCycle(b.dryrun), len(b.globalLogs.GetLogs())
 		b.globalLogs.Printf("start")
 		inAnHour := time.After(time.Hour)
 		allOK := true
+		anyHeld := false
+
+		// A removable target may be away; if so, plans are shown
+		// against its last-known inventory, but not executed.
+		remoteReady := b.checkTarget(ctx)
+
+		// Before anything else touches zfs, account for operations a
+		// previous process was killed in the middle of.
+		b.reconcileJournal()
 
 		// At launch: refresh all datasets and generate plans
 		if err := b.refreshAllDatasetsAndPlans(ctx); err != nil {
-			return fmt.Errorf("refreshing all datasets and plans: %w", err)
+			err = fmt.Errorf("refreshing all datasets and plans: %w", err)
+			b.finishCycle(cycle, logsFrom, err)
+			return err
 		}
 
+		if !remoteReady {
+			// Not alerting the snitch, so that a target which stays
+			// away too long is noticed.
+			b.globalLogs.Printf("removable target '%s' is away; waiting for it", b.config.Remote.RemovablePool)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(targetPollInterval):
+			case <-b.wakeCh:
+				b.globalLogs.Printf("woken early")
+			}
+			continue
+		}
+		b.saveCycle(cycle)
+
 		// Then, for each dataset: refresh, replan, resync
 		for _, ds := range b.state.Deref().ListDatasets() {
 			if err := ctx.Err(); err != nil {
+				b.finishCycle(cycle, logsFrom, err)
 				return err
 			}
 
@@ -204,18 +441,41 @@ func (b *Backupd) Sync(ctx context.Context) error {
 
 			// Resync with the updated plan
 			b.globalLogs.Printf("syncing '%s'", ds)
-			if err := b.syncDataset(ctx, ds); err != nil {
+			run := cycle.StartDataset(ds)
+			err := b.syncDataset(ctx, ds, cycle, run)
+			if errors.Is(err, errDeletionsHeld) {
+				anyHeld = true
+				run.Finish(history.StatusHeld, nil)
+				b.setLastSynced(ds, time.Now())
+				b.globalLogs.Printf("deletions for '%s' need approval; ran its transfers", ds)
+			} else if errors.Is(err, errPlanHeld) {
+				anyHeld = true
+				run.Finish(history.StatusHeld, nil)
+				b.globalLogs.Printf("plan for '%s' needs approval; skipping dataset", ds)
+			} else if err != nil {
 				allOK = false
+				run.Finish(history.StatusFailed, err)
 				err := fmt.Errorf("syncing '%s': %w", ds, err)
 				// Log to both global and dataset-specific logs
 				b.globalLogs.Printf("sync error; skipping dataset: %s", err)
 				// Also log to dataset-specific location if needed
+			} else {
+				run.Finish(history.StatusOK, nil)
+				b.setLastSynced(ds, time.Now())
 			}
+			if current := b.state.Deref().GetDataset(ds); current != nil && current.Logs != nil {
+				run.Logs = current.Logs.GetLogs()
+			}
+			b.saveCycle(cycle)
 		}
 
 		b.globalLogs.Printf("synced all datasets")
+		b.finishCycle(cycle, logsFrom, nil)
+		b.finishTarget(allOK)
 		if allOK {
-			if b.config.SnitchID != "" {
+			if anyHeld {
+				b.globalLogs.Printf("not alerting deadmanssnitch: some plans need approval")
+			} else if b.config.SnitchID != "" {
 				b.globalLogs.Printf("alerting deadmanssnitch")
 				if err := snitch.OK(b.config.SnitchID); err != nil {
 					b.globalLogs.Printf("snitch error: %v", err)
@@ -228,6 +488,8 @@ func (b *Backupd) Sync(ctx context.Context) error {
 			case <-ctx.Done():
 				return ctx.Err()
 			case <-inAnHour:
+			case <-b.wakeCh:
+				b.globalLogs.Printf("woken early")
 			}
 		} else {
 			b.globalLogs.Printf("back to top")
@@ -236,7 +498,17 @@ func (b *Backupd) Sync(ctx context.Context) error {
 }
 
 func (b *Backupd) refreshAllDatasetsAndPlans(ctx context.Context) error {
-	b.state.Reset(model.New())
+	// Start from scratch, except for restore jobs, which aren't derived
+	// from zfs state.
+	b.state.Swap(func(old *model.Model) *model.Model {
+		state := model.New()
+		if old != nil {
+			state.Restores = old.Restores
+			state.Target = old.Target
+			state.Warnings = old.Warnings
+		}
+		return state
+	})
 
 	// First, discover and refresh all datasets
 	localDatasets, err := b.env.Local.GetDatasets(b.globalLogs)
@@ -256,6 +528,18 @@ func (b *Backupd) refreshAllDatasetsAndPlans(ctx context.Context) error {
 		b.state.Swap(model.AddLocalDataset(datasetInfo.Name, snapshots, datasetInfo.Size))
 	}
 
+	if b.config.Local.Replica {
+		b.checkReplicaReadonly()
+	}
+
+	if target := b.state.Deref().Target; target != nil && !target.Present {
+		b.lastKnownRemote()
+		b.generatePlansForAllDatasets(ctx)
+		b.notifyDatasetsChange()
+		b.notifyStateChange()
+		return nil
+	}
+
 	remoteDatasets, err := b.env.Remote.GetDatasets(b.globalLogs)
 	if err != nil {
 		return fmt.Errorf("getting remote datasets: %w", err)
@@ -276,6 +560,7 @@ func (b *Backupd) refreshAllDatasetsAndPlans(ctx context.Context) error {
 	// Then generate plans for all datasets to show in UI
 	b.generatePlansForAllDatasets(ctx)
 
+	b.notifyDatasetsChange()
 	b.notifyStateChange()
 	return nil
 }
@@ -296,8 +581,7 @@ func (b *Backupd) generatePlansForAllDatasets(ctx context.Context) {
 		if ds.Current == nil {
 			continue
 		}
-		target := model.CalculateTargetInventory(ds.Current, b.config.Local.Policy, b.config.Remote.Policy)
-		plan, err := model.CalculateTransitionPlan(ds.Current, target)
+		target, plan, err := b.calculatePlan(dsName, ds.Current)
 		if err != nil {
 			// Log error but continue with other datasets
 			b.globalLogs.Printf("error generating plan for '%s': %s", dsName, err)
@@ -319,6 +603,8 @@ func (b *Backupd) generatePlansForAllDatasets(ctx context.Context) {
 }
 
 func (b *Backupd) refreshDataset(ctx context.Context, logger *logger.Logger, dataset model.DatasetName) error {
+	defer b.notifyDatasetChange(dataset)
+
 	// Refresh *local snapshots
 	localSnapshots, err := b.env.Local.GetSnapshots(logger, dataset)
 	if err != nil {
@@ -341,10 +627,11 @@ func (b *Backupd) refreshDataset(ctx context.Context, logger *logger.Logger, dat
 }
 
 // syncDataset executes the plan for the given dataset.
-func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) error {
+// Executed steps are recorded in run, and the cycle saved after each.
+func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName, cycle *history.Cycle, run *history.DatasetRun) error {
 	// Mark dataset as syncing
-	b.syncStatus.SetSyncing(dataset, true)
-	defer b.syncStatus.SetSyncing(dataset, false)
+	b.setSyncing(dataset, true)
+	defer b.setSyncing(dataset, false)
 
 	ds := b.state.Deref().GetDataset(dataset)
 	if ds == nil {
@@ -361,10 +648,12 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 		b.globalLogs.Printf("refresh error for '%s': %s", dataset, err)
 		return err
 	}
+	if err := b.checkSeed(ctx, ds.Logs, dataset); err != nil {
+		ds.Logs.Printf("checking seed: %v", err)
+	}
 
 	// Generate plan
-	target := model.CalculateTargetInventory(ds.Current, b.config.Local.Policy, b.config.Remote.Policy)
-	plan, err := model.CalculateTransitionPlan(ds.Current, target)
+	target, plan, err := b.calculatePlan(dataset, ds.Current)
 	if err != nil {
 		return fmt.Errorf("generating plan for '%s': %w", dataset, err)
 	}
@@ -375,12 +664,27 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 		state.SetPlan(dataset, plan)
 		return state
 	})
+	b.notifyDatasetChange(dataset)
 
 	// Validate the plan before execution
 	if err := model.ValidatePlan(ctx, ds.Current, target, plan, false); err != nil {
 		return fmt.Errorf("validating plan for '%s': %w", dataset, err)
 	}
 
+	// Don't execute plans which are waiting for approval, beyond the
+	// steps they don't hold
+	held := plan.Held()
+	if held {
+		ds.Logs.Printf("plan %s needs approval: %s", plan.Fingerprint(), plan.NeedsApproval)
+		if !plan.HoldsOnlyDeletions {
+			return errPlanHeld
+		}
+		ds.Logs.Printf("running its transfers meanwhile")
+	} else if plan.NeedsApproval != "" {
+		ds.Logs.Printf("executing approved plan %s", plan.Fingerprint())
+		defer b.consumeApproval(dataset)
+	}
+
 	// Store initial state for validation during execution
 	initialState := b.state.Deref()
 
@@ -388,6 +692,9 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 		if err := ctx.Err(); err != nil {
 			return err
 		}
+		if plan.HeldStep(step) {
+			continue
+		}
 
 		// Get logger from the step's ProcessLogs
 		stepLogger := step.Logs
@@ -429,7 +736,7 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 						updatedDS.Current = newInventory
 						return model.ReplaceDataset(dataset, updatedDS)(state)
 					})
-					b.notifyStateChange()
+					b.notifyDatasetChange(dataset)
 					stepLogger.Printf("-- [DRYRUN] Done.")
 					return nil
 				}
@@ -444,7 +751,13 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 				}
 
 				stepLogger.Printf("-- Updating zfs environment...")
-				if err := b.env.Apply(ctx, stepLogger, step); err != nil {
+				report := func(progress model.TransferProgress) {
+					b.updateStep(dataset, i, func(s *model.PlanStep) {
+						s.Progress = &progress
+					})
+				}
+				checksum, err := b.apply(ctx, stepLogger, step, initialDS.Current, report)
+				if err != nil {
 					if allowRetry && strings.Contains(err.Error(), "exit status 255") && attempts < 5 {
 						stepLogger.Printf("-- Got status code 255 on attempt %d; retrying", attempts)
 						time.Sleep(time.Minute * time.Duration(attempts))
@@ -453,6 +766,11 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 						return fmt.Errorf("applying op '%s' to zfs env (attempt %d) of '%s': %w", step, attempts, dataset, err)
 					}
 				}
+				if checksum != "" {
+					b.updateStep(dataset, i, func(s *model.PlanStep) {
+						s.Checksum = checksum
+					})
+				}
 
 				stepLogger.Printf("-- Updating in-memory state...")
 				b.state.Swap(func(state *model.Model) *model.Model {
@@ -470,7 +788,7 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 					updatedDS.Current = newInventory
 					return model.ReplaceDataset(dataset, updatedDS)(state)
 				})
-				b.notifyStateChange()
+				b.notifyDatasetChange(dataset)
 
 				stepLogger.Printf("-- Done.")
 				return nil
@@ -478,11 +796,20 @@ func (b *Backupd) syncDataset(ctx context.Context, dataset model.DatasetName) er
 
 		if err != nil {
 			stepLogger.Printf("-- Error: %s", err)
+		}
+		if ds := b.state.Deref().GetDataset(dataset); ds != nil && ds.Plan != nil && i < len(ds.Plan.Steps) {
+			run.AddStep(ds.Plan.Steps[i], err)
+			b.saveCycle(cycle)
+		}
+		if err != nil {
 			// Status is already set to Failed by TryExecute via updateStepStatus
 			return err
 		}
 	}
 
+	if held {
+		return errDeletionsHeld
+	}
 	return nil
 }
 
@@ -509,7 +836,7 @@ func (b *Backupd) handleIncompleteTransfer(ctx context.Context, logger *logger.L
 	}
 
 resume:
-	if err := b.env.Resume(ctx, logger, dataset, token); err != nil && strings.Contains(err.Error(), "contains partially-complete state") {
+	if _, err := b.env.Resume(ctx, logger, dataset, token, nil); err != nil && strings.Contains(err.Error(), "contains partially-complete state") {
 		logger.Printf("aborting resumable transfer")
 		if err := b.env.Remote.AbortResumable(logger, dataset); err != nil {
 			return fmt.Errorf("aborting resumable on '%s': %w", dataset, err)
@@ -525,10 +852,15 @@ resume:
 	return nil
 }
 
-func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
-	srv := http.Server{Addr: addr, Handler: handler}
+func listenAndServe(ctx context.Context, addr string, handler http.Handler, tlsConfig *tls.Config) error {
+	srv := http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
 	errs := make(chan error)
 	go func() {
+		if tlsConfig != nil {
+			// The certificate is already in tlsConfig.
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
 		errs <- srv.ListenAndServe()
 	}()
 	log.Printf("listening at %s", addr)
@@ -555,24 +887,40 @@ func (b *Backupd) Plan(ctx context.Context, dataset model.DatasetName) error {
 		return fmt.Errorf("dataset '%s' has no current inventory", dataset)
 	}
 
-	target := model.CalculateTargetInventory(ds.Current, b.config.Local.Policy, b.config.Remote.Policy)
+	target, plan, err := b.calculatePlan(dataset, ds.Current)
+	if err != nil {
+		return fmt.Errorf("constructing plan: %w", err)
+	}
 
 	// Store the target in the dataset for display purposes
 	updatedDS := ds.Clone()
 	updatedDS.Target = target
 	b.state.Swap(model.ReplaceDataset(dataset, updatedDS))
 
-	plan, err := model.CalculateTransitionPlan(ds.Current, target)
-	if err != nil {
-		return fmt.Errorf("constructing plan: %w", err)
-	}
-
 	fmt.Println("ACHIEVING CHANGE")
 	fmt.Print(ds.Current.Diff(target))
 	fmt.Println("VIA PLAN")
 	for _, op := range plan.Steps {
 		fmt.Printf("- %s\n", op)
 	}
+	if plan.NeedsApproval != "" {
+		fmt.Printf("NEEDS APPROVAL (plan %s)\n", plan.Fingerprint())
+		fmt.Printf("- %s\n", plan.NeedsApproval)
+	}
+	if plan.HeldBack != "" {
+		fmt.Println("HELD BACK")
+		fmt.Printf("- %s\n", plan.HeldBack)
+	}
+	fmt.Println("RETENTION")
+	for snap := range ds.Current.Local.Union(ds.Current.Remote).AllDesc() {
+		fmt.Printf("- %s\n", snap.Name)
+		if reason, ok := updatedDS.Retention(model.Local, snap); ok {
+			fmt.Printf("    local:  %s\n", reason)
+		}
+		if reason, ok := updatedDS.Retention(model.Remote, snap); ok {
+			fmt.Printf("    remote: %s\n", reason)
+		}
+	}
 
 	if err := model.ValidatePlan(ctx, ds.Current, target, plan, true); err != nil {
 		return fmt.Errorf("invalid plan: %w", err)
@@ -617,15 +965,7 @@ func (b *Backupd) RefreshLocalSnapshots(ctx context.Context, logger *logger.Logg
 
 // CreateSnapshot sends a request to the running daemon to create a snapshot
 func (b *Backupd) CreateSnapshot(ctx context.Context, periodicity string) error {
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	url := fmt.Sprintf("http://%s/snapshot?periodicity=%s", b.addr, periodicity)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
-	if err != nil {
-		return fmt.Errorf("creating request: %w", err)
-	}
-
-	resp, err := client.Do(req)
+	resp, err := b.callDaemon(ctx, 30*time.Second, "POST", "/snapshot?periodicity="+url.QueryEscape(periodicity), nil)
 	if err != nil {
 		return fmt.Errorf("calling snapshot endpoint: %w", err)
 	}
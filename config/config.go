@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -10,18 +11,145 @@ import (
 
 type Config struct {
 	SnitchID string `toml:"snitch_id"`
-	Remote   struct {
+
+	// Tiers orders snapshot types from highest to lowest, e.g. ["yearly",
+	// "monthly", "weekly", "daily", "hourly"]. A snapshot also counts
+	// toward the policy quotas of every tier below its own type.
+	Tiers []string `toml:"tiers"`
+
+	// StateDir is where backupd keeps what must outlive the process,
+	// such as the history of sync cycles. It defaults to DefaultStateDir.
+	StateDir string `toml:"state_dir"`
+
+	Remote struct {
 		SSHKey  string         `toml:"ssh_key"`
 		SSHHost string         `toml:"ssh_host"`
 		Policy  map[string]int `toml:"policy"`
 		Root    string         `toml:"root"`
+
+		// MinAge keeps remote snapshots younger than it, and MaxAge
+		// expires those older than it, whatever Policy's counts.
+		MinAge Duration `toml:"min_age"`
+		MaxAge Duration `toml:"max_age"`
+
+		// MaxDeletions and MaxDeletionShare (a fraction, e.g. 0.5) cap
+		// how many remote snapshots one plan may delete. Plans over
+		// either limit are held until approved. Zero means no limit.
+		MaxDeletions     int     `toml:"max_deletions"`
+		MaxDeletionShare float64 `toml:"max_deletion_share"`
+
+		// VerifyChecksum pipes receives on the remote through `backupd
+		// receive`, which checksums the stream so it can be compared with
+		// what was sent. It requires backupd on the remote at HelperPath
+		// (default "backupd", from the remote's $PATH). Restores are
+		// received through `backupd receive` on the local side.
+		VerifyChecksum bool   `toml:"verify_checksum"`
+		HelperPath     string `toml:"helper_path"`
+
+		// OrphanRecovery controls what happens when a remote dataset
+		// shares no snapshots with local. With "off" (the default), the
+		// dataset is skipped. With "reseed", backupd proposes renaming
+		// the remote dataset aside and sending local from scratch, and
+		// does so once the proposal is approved. Set-aside datasets are
+		// thinned by OrphanPolicy, or kept whole if it's empty.
+		OrphanRecovery string         `toml:"orphan_recovery"`
+		OrphanPolicy   map[string]int `toml:"orphan_policy"`
+
+		// Retention is "mirror" (the default), where the remote policy
+		// picks from every snapshot either side has, or "archive", where
+		// every local snapshot is transferred before local may delete it,
+		// and the remote policy thins only the remote's own snapshots.
+		Retention string `toml:"retention"`
+
+		// RemovablePool makes the remote a removable target: the pool
+		// with this name or GUID is only synced while it's imported.
+		// With AutoImport, backupd imports it when its drive is attached
+		// and exports it again after each sync.
+		RemovablePool string `toml:"removable_pool"`
+		AutoImport    bool   `toml:"auto_import"`
+
+		// Transport is how streams reach the remote: "ssh" (the
+		// default), or "tcp" to a `backupd receive-server` at
+		// ReceiveAddr, authenticated with mutual TLS (TLSCert, TLSKey,
+		// and TLSCA, which signed the server's certificate) and
+		// optionally a pre-shared key in PSKFile. Other commands still
+		// use SSH.
+		Transport   string `toml:"transport"`
+		ReceiveAddr string `toml:"receive_addr"`
+		TLSCert     string `toml:"tls_cert"`
+		TLSKey      string `toml:"tls_key"`
+		TLSCA       string `toml:"tls_ca"`
+		PSKFile     string `toml:"psk_file"`
 	} `toml:"remote"`
 	Local struct {
 		Policy map[string]int `toml:"policy"`
 		Root   string         `toml:"root"`
+
+		// MinAge keeps local snapshots younger than it, and MaxAge
+		// expires those older than it, whatever Policy's counts.
+		MinAge Duration `toml:"min_age"`
+		MaxAge Duration `toml:"max_age"`
+
+		// MaxDeletions and MaxDeletionShare cap how many local
+		// snapshots one plan may delete, as for the remote.
+		MaxDeletions     int     `toml:"max_deletions"`
+		MaxDeletionShare float64 `toml:"max_deletion_share"`
+
+		// If SSHHost is set, backupd runs in pull mode: the daemon runs
+		// on the backup server, reaches the production ("local") host
+		// over SSH, and receives into its own pools as the "remote".
+		SSHKey  string `toml:"ssh_key"`
+		SSHHost string `toml:"ssh_host"`
+
+		// Replica marks the local tree as received from an upstream
+		// backupd, for cascading replication. Its snapshots belong to
+		// upstream: backupd never destroys or creates them, so Policy
+		// must be empty, and it doesn't restore into them.
+		Replica bool `toml:"replica"`
 	}
+
+	// HTTP secures the web UI and API. Without users or a client CA,
+	// anyone who can reach the server's address may do anything.
+	HTTP struct {
+		// TLSCert and TLSKey serve over HTTPS. With ClientCA, clients
+		// may authenticate with a certificate it signed, named by its
+		// common name and given ClientRole ("read" by default).
+		TLSCert    string `toml:"tls_cert"`
+		TLSKey     string `toml:"tls_key"`
+		ClientCA   string `toml:"client_ca"`
+		ClientRole string `toml:"client_role"`
+
+		Users []HTTPUser `toml:"user"`
+	} `toml:"http"`
+}
+
+// HTTPUser may use the web UI and API, with the token in TokenFile as a
+// bearer token, and/or with its name and the password in PasswordFile over
+// HTTP basic auth. Role is "read", to view, or "operator", to also make
+// changes such as creating snapshots and approving plans.
+type HTTPUser struct {
+	Name         string `toml:"name"`
+	Role         string `toml:"role"`
+	TokenFile    string `toml:"token_file"`
+	PasswordFile string `toml:"password_file"`
+}
+
+// RemoteIsLocal reports whether the remote side is on this machine, either
+// because it has no SSH host (a second pool on the same machine) or because
+// of pull mode.
+func (conf *Config) RemoteIsLocal() bool {
+	return conf.Remote.SSHHost == ""
+}
+
+// PullMode reports whether the local side is reached over SSH, with the
+// remote side on this machine.
+func (conf *Config) PullMode() bool {
+	return conf.Local.SSHHost != ""
 }
 
+// DefaultStateDir is the state directory used if state_dir isn't set.
+const DefaultStateDir = "/var/db/backupd"
+
 var pathHierarchy = []string{
 	"/etc/backupd.toml",
 	"/usr/local/etc/backupd.toml",
@@ -40,14 +168,152 @@ func Load() (*Config, error) {
 
 		defer f.Close()
 
-		dec := toml.NewDecoder(f)
-		var conf Config
-		if _, err := dec.Decode(&conf); err != nil {
-			return nil, fmt.Errorf("decoding '%s': %w", path, err)
+		return Read(f, path)
+	}
+
+	return nil, fmt.Errorf("no config file exists {%s}", strings.Join(pathHierarchy, ", "))
+}
+
+// Read decodes and validates a config, such as a proposed one. The name is
+// used in errors.
+func Read(r io.Reader, name string) (*Config, error) {
+	dec := toml.NewDecoder(r)
+	var conf Config
+	if _, err := dec.Decode(&conf); err != nil {
+		return nil, fmt.Errorf("decoding '%s': %w", name, err)
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, fmt.Errorf("validating '%s': %w", name, err)
+	}
+	if conf.StateDir == "" {
+		conf.StateDir = DefaultStateDir
+	}
+
+	return &conf, nil
+}
+
+func (conf *Config) validate() error {
+	switch conf.Remote.OrphanRecovery {
+	case "", "off", "reseed":
+	default:
+		return fmt.Errorf("remote.orphan_recovery must be \"off\" or \"reseed\", not %q", conf.Remote.OrphanRecovery)
+	}
+	if conf.PullMode() && conf.Remote.SSHHost != "" {
+		return fmt.Errorf("remote.ssh_host must be empty when local.ssh_host is set: in pull mode, the remote is this machine")
+	}
+	if conf.Local.Replica && len(conf.Local.Policy) > 0 {
+		return fmt.Errorf("local.policy must be empty when local.replica is set: upstream manages the replica's snapshots")
+	}
+	if conf.Local.MaxAge > 0 && conf.Local.MinAge > conf.Local.MaxAge {
+		return fmt.Errorf("local.min_age must not exceed local.max_age")
+	}
+	if conf.Remote.MaxAge > 0 && conf.Remote.MinAge > conf.Remote.MaxAge {
+		return fmt.Errorf("remote.min_age must not exceed remote.max_age")
+	}
+	if conf.Local.MaxDeletions < 0 || conf.Remote.MaxDeletions < 0 {
+		return fmt.Errorf("max_deletions must not be negative")
+	}
+	if share := conf.Local.MaxDeletionShare; share < 0 || share > 1 {
+		return fmt.Errorf("local.max_deletion_share must be between 0 and 1, not %g", share)
+	}
+	if share := conf.Remote.MaxDeletionShare; share < 0 || share > 1 {
+		return fmt.Errorf("remote.max_deletion_share must be between 0 and 1, not %g", share)
+	}
+	seen := map[string]bool{}
+	for _, tier := range conf.Tiers {
+		if tier == "" || strings.Contains(tier, "-") {
+			return fmt.Errorf("tiers: invalid snapshot type %q", tier)
 		}
+		if seen[tier] {
+			return fmt.Errorf("tiers: %q is listed twice", tier)
+		}
+		seen[tier] = true
+	}
+	switch conf.Remote.Retention {
+	case "", "mirror", "archive":
+	default:
+		return fmt.Errorf("remote.retention must be \"mirror\" or \"archive\", not %q", conf.Remote.Retention)
+	}
+	if err := conf.validateTransport(); err != nil {
+		return err
+	}
+	if err := conf.validateHTTP(); err != nil {
+		return err
+	}
+	if conf.Remote.Root == "" {
+		// An empty root would make every dataset on the remote's machine
+		// part of the backup.
+		return fmt.Errorf("remote.root is required")
+	}
+	if !conf.PullMode() && conf.RemoteIsLocal() && overlaps(conf.Local.Root, conf.Remote.Root) {
+		// With both sides in the same pool namespace, one root inside the
+		// other would have backupd back up its own backups.
+		return fmt.Errorf("local.root %q and remote.root %q must not contain one another when the remote is on this machine", conf.Local.Root, conf.Remote.Root)
+	}
+	return nil
+}
 
-		return &conf, nil
+func (conf *Config) validateTransport() error {
+	remote := conf.Remote
+	switch remote.Transport {
+	case "", "ssh":
+		return nil
+	case "tcp":
+	default:
+		return fmt.Errorf("remote.transport must be \"ssh\" or \"tcp\", not %q", remote.Transport)
 	}
 
-	return nil, fmt.Errorf("no config file exists {%s}", strings.Join(pathHierarchy, ", "))
+	if conf.RemoteIsLocal() {
+		return fmt.Errorf("remote.transport \"tcp\" needs a remote.ssh_host for other commands")
+	}
+	if remote.ReceiveAddr == "" {
+		return fmt.Errorf("remote.transport \"tcp\" needs remote.receive_addr")
+	}
+	if remote.TLSCert == "" || remote.TLSKey == "" || remote.TLSCA == "" {
+		return fmt.Errorf("remote.transport \"tcp\" needs remote.tls_cert, remote.tls_key, and remote.tls_ca, even with remote.psk_file")
+	}
+	return nil
+}
+
+func (conf *Config) validateHTTP() error {
+	web := conf.HTTP
+	if (web.TLSCert == "") != (web.TLSKey == "") {
+		return fmt.Errorf("http.tls_cert and http.tls_key must be set together")
+	}
+	if web.ClientCA != "" && web.TLSCert == "" {
+		return fmt.Errorf("http.client_ca needs http.tls_cert and http.tls_key")
+	}
+	switch web.ClientRole {
+	case "", "read", "operator":
+	default:
+		return fmt.Errorf("http.client_role must be \"read\" or \"operator\", not %q", web.ClientRole)
+	}
+	if web.ClientRole != "" && web.ClientCA == "" {
+		return fmt.Errorf("http.client_role needs http.client_ca")
+	}
+	seen := map[string]bool{}
+	for _, user := range web.Users {
+		if user.Name == "" || strings.Contains(user.Name, ":") {
+			return fmt.Errorf("http.user: invalid name %q", user.Name)
+		}
+		if seen[user.Name] {
+			return fmt.Errorf("http.user: %q is listed twice", user.Name)
+		}
+		seen[user.Name] = true
+		switch user.Role {
+		case "read", "operator":
+		default:
+			return fmt.Errorf("http.user %q: role must be \"read\" or \"operator\", not %q", user.Name, user.Role)
+		}
+		if user.TokenFile == "" && user.PasswordFile == "" {
+			return fmt.Errorf("http.user %q needs a token_file or password_file", user.Name)
+		}
+	}
+	return nil
+}
+
+func overlaps(a, b string) bool {
+	a, b = strings.TrimSuffix(a, "/"), strings.TrimSuffix(b, "/")
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
 }
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateLocalRemote(t *testing.T) {
+	for _, tc := range []struct {
+		localRoot, remoteRoot, remoteHost string
+		ok                                bool
+	}{
+		{"tank/data", "usb/backups", "", true},
+		{"tank/data", "tank/data-backups", "", true},
+		{"tank/data", "tank/data/backups", "", false},
+		{"tank", "tank/backups", "", false},
+		{"tank/data", "tank/data", "", false},
+		{"tank/data", "tank/data", "backup@host", true},
+		{"tank/data", "", "", false},
+	} {
+		var conf Config
+		conf.Local.Root = tc.localRoot
+		conf.Remote.Root = tc.remoteRoot
+		conf.Remote.SSHHost = tc.remoteHost
+		if err := conf.validate(); (err == nil) != tc.ok {
+			t.Errorf("local %q, remote %q on %q: expected ok=%v, got %v", tc.localRoot, tc.remoteRoot, tc.remoteHost, tc.ok, err)
+		}
+	}
+}
+
+func TestValidateTransport(t *testing.T) {
+	for name, tc := range map[string]struct {
+		configure func(*Config)
+		ok        bool
+	}{
+		"default": {func(*Config) {}, true},
+		"unknown": {func(c *Config) { c.Remote.Transport = "udp" }, false},
+		"tcp with only psk": {func(c *Config) {
+			c.Remote.Transport, c.Remote.ReceiveAddr, c.Remote.PSKFile = "tcp", "backup:9099", "/etc/psk"
+		}, false},
+		"tcp with tls and psk": {func(c *Config) {
+			c.Remote.Transport, c.Remote.ReceiveAddr, c.Remote.PSKFile = "tcp", "backup:9099", "/etc/psk"
+			c.Remote.TLSCert, c.Remote.TLSKey, c.Remote.TLSCA = "c", "k", "ca"
+		}, true},
+		"tcp with tls": {func(c *Config) {
+			c.Remote.Transport, c.Remote.ReceiveAddr = "tcp", "backup:9099"
+			c.Remote.TLSCert, c.Remote.TLSKey, c.Remote.TLSCA = "c", "k", "ca"
+		}, true},
+		"tcp with partial tls": {func(c *Config) {
+			c.Remote.Transport, c.Remote.ReceiveAddr = "tcp", "backup:9099"
+			c.Remote.TLSCert, c.Remote.TLSKey = "c", "k"
+		}, false},
+		"tcp without auth": {func(c *Config) { c.Remote.Transport, c.Remote.ReceiveAddr = "tcp", "backup:9099" }, false},
+		"tcp without address": {func(c *Config) {
+			c.Remote.Transport = "tcp"
+			c.Remote.TLSCert, c.Remote.TLSKey, c.Remote.TLSCA = "c", "k", "ca"
+		}, false},
+		"tcp to local pool": {func(c *Config) {
+			c.Remote.Transport, c.Remote.ReceiveAddr = "tcp", "backup:9099"
+			c.Remote.TLSCert, c.Remote.TLSKey, c.Remote.TLSCA = "c", "k", "ca"
+			c.Remote.SSHHost = ""
+		}, false},
+	} {
+		var conf Config
+		conf.Local.Root = "tank/data"
+		conf.Remote.Root = "tank/backups"
+		conf.Remote.SSHHost = "backup@host"
+		tc.configure(&conf)
+		if err := conf.validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %v", name, tc.ok, err)
+		}
+	}
+}
+
+func TestValidateHTTP(t *testing.T) {
+	for name, tc := range map[string]struct {
+		configure func(*Config)
+		ok        bool
+	}{
+		"default":     {func(*Config) {}, true},
+		"tls":         {func(c *Config) { c.HTTP.TLSCert, c.HTTP.TLSKey = "c", "k" }, true},
+		"partial tls": {func(c *Config) { c.HTTP.TLSCert = "c" }, false},
+		"mtls": {func(c *Config) {
+			c.HTTP.TLSCert, c.HTTP.TLSKey, c.HTTP.ClientCA, c.HTTP.ClientRole = "c", "k", "ca", "operator"
+		}, true},
+		"client ca without tls":  {func(c *Config) { c.HTTP.ClientCA = "ca" }, false},
+		"client role without ca": {func(c *Config) { c.HTTP.ClientRole = "read" }, false},
+		"users": {func(c *Config) {
+			c.HTTP.Users = []HTTPUser{
+				{Name: "grafana", Role: "read", TokenFile: "/etc/token"},
+				{Name: "admin", Role: "operator", PasswordFile: "/etc/password"},
+			}
+		}, true},
+		"user without role":   {func(c *Config) { c.HTTP.Users = []HTTPUser{{Name: "a", TokenFile: "t"}} }, false},
+		"user without secret": {func(c *Config) { c.HTTP.Users = []HTTPUser{{Name: "a", Role: "read"}} }, false},
+		"user with colon":     {func(c *Config) { c.HTTP.Users = []HTTPUser{{Name: "a:b", Role: "read", TokenFile: "t"}} }, false},
+		"user listed twice": {func(c *Config) {
+			c.HTTP.Users = []HTTPUser{{Name: "a", Role: "read", TokenFile: "t"}, {Name: "a", Role: "operator", TokenFile: "u"}}
+		}, false},
+	} {
+		var conf Config
+		conf.Local.Root = "tank/data"
+		conf.Remote.Root = "usb/backups"
+		tc.configure(&conf)
+		if err := conf.validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %v", name, tc.ok, err)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for text, want := range map[string]time.Duration{
+		"90m": 90 * time.Minute,
+		"36h": 36 * time.Hour,
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	} {
+		var d Duration
+		if err := d.UnmarshalText([]byte(text)); err != nil {
+			t.Errorf("%s: %v", text, err)
+		} else if time.Duration(d) != want {
+			t.Errorf("%s: got %s, want %s", text, time.Duration(d), want)
+		}
+	}
+	var d Duration
+	if err := d.UnmarshalText([]byte("soon")); err == nil {
+		t.Errorf("expected an error for an invalid duration")
+	}
+}
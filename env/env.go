@@ -3,6 +3,7 @@ package env
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"path"
 
@@ -13,120 +14,258 @@ import (
 
 type Env struct {
 	Local, Remote *ZFS
+
+	// If verify is set, receives on the remote go through the `backupd
+	// receive` helper at helperPath, so that streams can be checksummed
+	// end to end. Restores, received on the local side, go through the
+	// helper at localHelperPath.
+	verify          bool
+	helperPath      string
+	localHelperPath string
+
+	// If tcp is set, streams to the remote go to its `backupd
+	// receive-server` through `backupd receive-client`, run locally from
+	// selfPath.
+	tcp      bool
+	selfPath string
 }
 
 func New(config *config.Config) *Env {
+	// Normally the daemon runs on the local side and sends to the remote
+	// over SSH. In pull mode, it runs on the remote and reaches the local
+	// side over SSH instead; and a remote without an SSH host is another
+	// pool on this machine. Planning is the same either way, and Pipe just
+	// streams from whichever side sends.
+	var local, remote Executor = Local, Local
+	if config.PullMode() {
+		local = NewRemote(config.Local.SSHKey, config.Local.SSHHost)
+	} else if !config.RemoteIsLocal() {
+		remote = NewRemote(config.Remote.SSHKey, config.Remote.SSHHost)
+	}
+
+	// When receiving on this machine, the helper is this binary.
+	helperPath := config.Remote.HelperPath
+	if helperPath == "" && config.RemoteIsLocal() {
+		helperPath, _ = os.Executable()
+	}
+	if helperPath == "" {
+		helperPath = "backupd"
+	}
+
+	selfPath, err := os.Executable()
+	if err != nil {
+		selfPath = "backupd"
+	}
+
+	// The local side is this machine, unless in pull mode.
+	localHelperPath := selfPath
+	if config.PullMode() {
+		localHelperPath = "backupd"
+	}
+
 	return &Env{
-		Local: NewZFS(config.Local.Root, Local),
-		Remote: NewZFS(
-			config.Remote.Root,
-			NewRemote(
-				config.Remote.SSHKey,
-				config.Remote.SSHHost,
-			),
-		),
+		verify:          config.Remote.VerifyChecksum,
+		helperPath:      helperPath,
+		localHelperPath: localHelperPath,
+		tcp:             config.Remote.Transport == "tcp",
+		selfPath:        selfPath,
+		Local:           NewZFS(config.Local.Root, local),
+		Remote:          NewZFS(config.Remote.Root, remote),
 	}
 }
 
-func (env *Env) Resume(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, token string) error {
-	if env.Local.readOnly || env.Remote.readOnly {
-		panic("read only")
+// receive returns a command which receives a stream into the given remote
+// dataset, using the checksumming helper if verification is enabled, or
+// the TCP transport if it's configured.
+func (env *Env) receive(args ...string) *exec.Cmd {
+	if env.tcp {
+		return Local.Command(append([]string{env.selfPath, "receive-client"}, args...)...)
+	}
+	if env.verify {
+		return env.Remote.x.Command(append([]string{env.helperPath, "receive"}, args...)...)
+	}
+	return env.Remote.Receive(args...)
+}
+
+// restoreReceive returns a command which receives a restored stream into
+// the given local dataset, using the checksumming helper if verification is
+// enabled.
+func (env *Env) restoreReceive(args ...string) *exec.Cmd {
+	if env.verify {
+		return env.Local.x.Command(append([]string{env.localHelperPath, "receive"}, args...)...)
 	}
-	remote := env.Remote.x.(*Remote)
+	return env.Local.Receive(args...)
+}
+
+// pipe runs Pipe, enforcing that the stream was verified if verification is
+// enabled. It returns the verified checksum.
+func (env *Env) pipe(ctx context.Context, logger *logger.Logger, size int64, send, recv *exec.Cmd, report func(model.TransferProgress)) (string, error) {
+	return env.pipeVia(ctx, logger, env.helperPath, "remote", size, send, recv, report)
+}
 
-	send := exec.Command("zfs", "send", "--raw", "-t", token)
-	recv := exec.Command("ssh", "-i", remote.sshKey, remote.sshHost,
-		fmt.Sprintf("zfs receive -s %s", env.Remote.WithPrefix(dataset)))
+// restorePipe is pipe for restores, which receive on the local side.
+func (env *Env) restorePipe(ctx context.Context, logger *logger.Logger, size int64, send, recv *exec.Cmd, report func(model.TransferProgress)) (string, error) {
+	return env.pipeVia(ctx, logger, env.localHelperPath, "local side", size, send, recv, report)
+}
 
-	size, err := env.Local.Size(logger, send)
+func (env *Env) pipeVia(ctx context.Context, logger *logger.Logger, helper, side string, size int64, send, recv *exec.Cmd, report func(model.TransferProgress)) (string, error) {
+	checksum, err := Pipe(ctx, logger, size, send, recv, report)
 	if err != nil {
-		return fmt.Errorf("getting size of resume: %w", err)
+		return "", err
+	}
+	if env.verify && checksum == "" {
+		return "", fmt.Errorf("receiver did not report a checksum; is '%s' installed on the %s?", helper, side)
+	}
+	return checksum, nil
+}
+
+func (env *Env) Resume(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, token string, report func(model.TransferProgress)) (string, error) {
+	if env.Local.readOnly || env.Remote.readOnly {
+		panic("read only")
 	}
 
-	if err := Pipe(ctx, logger, size, send, recv); err != nil {
-		return err
+	sendArgs := []string{"--raw", "-t", token}
+	send := env.Local.Send(sendArgs...)
+	recv := env.receive("-s", env.Remote.WithPrefix(dataset))
+
+	size, err := env.Local.Size(logger, sendArgs...)
+	if err != nil {
+		return "", fmt.Errorf("getting size of resume: %w", err)
 	}
 
-	return nil
+	return env.pipe(ctx, logger, size, send, recv, report)
 }
 
-func (env *Env) TransferInitialSnapshot(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, snapshot string) error {
+func (env *Env) TransferInitialSnapshot(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, snapshot string, report func(model.TransferProgress)) (string, error) {
 	if env.Local.readOnly || env.Remote.readOnly {
 		panic("read only")
 	}
-	remote := env.Remote.x.(*Remote)
 
 	// Ensure parent dataset exists on the remote so zfs receive can create
 	// the leaf dataset. Without this, receives into nested paths like
 	// /home/thor fail because the intermediate /home dataset doesn't exist.
 	if parent := path.Dir(dataset.Path()); parent != "." && parent != "/" {
 		if err := env.Remote.CreateDataset(logger, model.DatasetName(parent)); err != nil {
-			return fmt.Errorf("creating parent dataset '%s' on remote: %w", parent, err)
+			return "", fmt.Errorf("creating parent dataset '%s' on remote: %w", parent, err)
 		}
 	}
 
-	send := exec.Command("zfs", "send", "--raw",
-		fmt.Sprintf("%s@%s", env.Local.WithPrefix(dataset), snapshot))
-	recv := exec.Command("ssh", "-i", remote.sshKey, remote.sshHost,
-		fmt.Sprintf("zfs receive -s %s", env.Remote.WithPrefix(dataset)))
+	sendArgs := []string{"--raw",
+		fmt.Sprintf("%s@%s", env.Local.WithPrefix(dataset), snapshot)}
+	send := env.Local.Send(sendArgs...)
+	recv := env.receive("-s", env.Remote.WithPrefix(dataset))
 
-	size, err := env.Local.Size(logger, send)
+	size, err := env.Local.Size(logger, sendArgs...)
 	if err != nil {
-		return fmt.Errorf("getting size of transfer '%s': %w", snapshot, err)
+		return "", fmt.Errorf("getting size of transfer '%s': %w", snapshot, err)
 	}
 
-	if err := Pipe(ctx, logger, size, send, recv); err != nil {
-		return err
+	return env.pipe(ctx, logger, size, send, recv, report)
+}
+
+func (env *Env) TransferSnapshot(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, snapshot string, report func(model.TransferProgress)) (string, error) {
+	if env.Local.readOnly || env.Remote.readOnly {
+		panic("read only")
 	}
 
-	return nil
+	sendArgs := []string{"--raw",
+		fmt.Sprintf("%s %s", env.Local.WithPrefix(dataset), snapshot)}
+	send := env.Local.Send(sendArgs...)
+	recv := env.receive("-s", "-F", env.Remote.WithPrefix(dataset))
+
+	size, err := env.Local.Size(logger, sendArgs...)
+	if err != nil {
+		return "", fmt.Errorf("getting size of transfer '%s': %w", snapshot, err)
+	}
+
+	return env.pipe(ctx, logger, size, send, recv, report)
 }
 
-func (env *Env) TransferSnapshot(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, snapshot string) error {
+func (env *Env) TransferSnapshotIncrementally(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, from, to string, report func(model.TransferProgress)) (string, error) {
 	if env.Local.readOnly || env.Remote.readOnly {
 		panic("read only")
 	}
-	remote := env.Remote.x.(*Remote)
 
-	send := exec.Command("zfs", "send", "--raw",
-		fmt.Sprintf("%s %s", env.Local.WithPrefix(dataset), snapshot))
-	recv := exec.Command("ssh", "-i", remote.sshKey, remote.sshHost,
-		fmt.Sprintf("zfs receive -s -F %s", env.Remote.WithPrefix(dataset)))
+	sendArgs := []string{"--raw", "-i",
+		fmt.Sprintf("%s@%s", env.Local.WithPrefix(dataset), from),
+		fmt.Sprintf("%s@%s", env.Local.WithPrefix(dataset), to)}
+	send := env.Local.Send(sendArgs...)
+	recv := env.receive("-s", "-F", env.Remote.WithPrefix(dataset))
 
-	size, err := env.Local.Size(logger, send)
+	size, err := env.Local.Size(logger, sendArgs...)
 	if err != nil {
-		return fmt.Errorf("getting size of transfer '%s': %w", snapshot, err)
+		return "", fmt.Errorf("getting size of range transfer from '%s' to '%s': %w", from, to, err)
 	}
 
-	if err := Pipe(ctx, logger, size, send, recv); err != nil {
-		return err
+	return env.pipe(ctx, logger, size, send, recv, report)
+}
+
+// ResumeRestore resumes an interrupted restore into the local dataset `to`.
+func (env *Env) ResumeRestore(ctx context.Context, logger *logger.Logger, to model.DatasetName, token string, report func(model.TransferProgress)) (string, error) {
+	if env.Local.readOnly || env.Remote.readOnly {
+		panic("read only")
 	}
 
-	return nil
+	sendArgs := []string{"--raw", "-t", token}
+	send := env.Remote.Send(sendArgs...)
+	recv := env.restoreReceive("-s", env.Local.WithPrefix(to))
+
+	size, err := env.Remote.Size(logger, sendArgs...)
+	if err != nil {
+		return "", fmt.Errorf("getting size of resume: %w", err)
+	}
+
+	return env.restorePipe(ctx, logger, size, send, recv, report)
 }
 
-func (env *Env) TransferSnapshotIncrementally(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, from, to string) error {
+// RestoreInitialSnapshot pulls a full copy of a remote snapshot into the
+// local dataset `to`, which must not exist yet.
+func (env *Env) RestoreInitialSnapshot(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, snapshot string, to model.DatasetName, report func(model.TransferProgress)) (string, error) {
 	if env.Local.readOnly || env.Remote.readOnly {
 		panic("read only")
 	}
-	remote := env.Remote.x.(*Remote)
 
-	send := exec.Command("zfs", "send", "--raw", "-i",
-		fmt.Sprintf("%s@%s", env.Local.WithPrefix(dataset), from),
-		fmt.Sprintf("%s@%s", env.Local.WithPrefix(dataset), to))
-	recv := exec.Command("ssh", "-i", remote.sshKey, remote.sshHost,
-		fmt.Sprintf("zfs receive -s -F %s", env.Remote.WithPrefix(dataset)))
+	if parent := path.Dir(to.Path()); parent != "." && parent != "/" {
+		if err := env.Local.CreateDataset(logger, model.DatasetName(parent)); err != nil {
+			return "", fmt.Errorf("creating parent dataset '%s' on local: %w", parent, err)
+		}
+	}
+
+	sendArgs := []string{"--raw",
+		fmt.Sprintf("%s@%s", env.Remote.WithPrefix(dataset), snapshot)}
+	send := env.Remote.Send(sendArgs...)
+	recv := env.restoreReceive("-s", env.Local.WithPrefix(to))
 
-	size, err := env.Local.Size(logger, send)
+	size, err := env.Remote.Size(logger, sendArgs...)
 	if err != nil {
-		return fmt.Errorf("getting size of range transfer from '%s' to '%s': %w", from, to, err)
+		return "", fmt.Errorf("getting size of restore '%s': %w", snapshot, err)
 	}
 
-	if err := Pipe(ctx, logger, size, send, recv); err != nil {
-		return err
+	return env.restorePipe(ctx, logger, size, send, recv, report)
+}
+
+// RestoreSnapshotIncrementally pulls the changes between two remote
+// snapshots into the local dataset `to`, whose newest snapshot must be
+// `from`. Unlike sends to the remote, this does not use `receive -F`: if
+// the local dataset was modified since `from`, the restore fails rather
+// than discarding those changes.
+func (env *Env) RestoreSnapshotIncrementally(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, from, snapshot string, to model.DatasetName, report func(model.TransferProgress)) (string, error) {
+	if env.Local.readOnly || env.Remote.readOnly {
+		panic("read only")
 	}
 
-	return nil
+	sendArgs := []string{"--raw", "-i",
+		fmt.Sprintf("%s@%s", env.Remote.WithPrefix(dataset), from),
+		fmt.Sprintf("%s@%s", env.Remote.WithPrefix(dataset), snapshot)}
+	send := env.Remote.Send(sendArgs...)
+	recv := env.restoreReceive("-s", env.Local.WithPrefix(to))
+
+	size, err := env.Remote.Size(logger, sendArgs...)
+	if err != nil {
+		return "", fmt.Errorf("getting size of range restore from '%s' to '%s': %w", from, snapshot, err)
+	}
+
+	return env.restorePipe(ctx, logger, size, send, recv, report)
 }
 
 // CreateSnapshotRecursively creates a recursive snapshot for the configured root
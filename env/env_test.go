@@ -0,0 +1,82 @@
+package env
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"monks.co/backupd/logger"
+)
+
+// fakeSide stands in for one side's zfs: it sends "hello\n", and receives
+// by discarding the stream and running receiver.
+type fakeSide struct {
+	receiver string
+	commands [][]string
+}
+
+func (side *fakeSide) Exec(logger *logger.Logger, cmd ...string) ([]string, error) {
+	return []string{"size\t6"}, nil
+}
+
+func (side *fakeSide) Execf(logger *logger.Logger, s string, args ...any) ([]string, error) {
+	return nil, nil
+}
+
+func (side *fakeSide) Command(cmd ...string) *exec.Cmd {
+	side.commands = append(side.commands, cmd)
+	if slices.Contains(cmd, "send") {
+		return exec.Command("echo", "hello")
+	}
+	return exec.Command("sh", "-c", "cat >/dev/null; "+side.receiver)
+}
+
+func TestRestoreVerifiesChecksum(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		receiver string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "verified",
+			receiver: "echo " + checksumPrefix + helloChecksum,
+			want:     helloChecksum,
+		},
+		{
+			name:     "no checksum reported",
+			receiver: "true",
+			wantErr:  "did not report a checksum",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			local := &fakeSide{receiver: tc.receiver}
+			env := &Env{
+				verify:          true,
+				localHelperPath: "/usr/local/bin/backupd",
+				Local:           NewZFS("tank", local),
+				Remote:          NewZFS("backup", &fakeSide{}),
+			}
+
+			got, err := env.RestoreSnapshotIncrementally(context.Background(), logger.New("test"), "/home", "daily-1", "daily-2", "/home", nil)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected checksum %q, got %q", tc.want, got)
+			}
+			want := []string{"/usr/local/bin/backupd", "receive", "-s", "tank/home"}
+			if len(local.commands) != 1 || !slices.Equal(local.commands[0], want) {
+				t.Errorf("expected the restore to be received by %q, got %q", want, local.commands)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package env
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os/exec"
@@ -14,10 +17,12 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
 )
 
 const (
 	throughputLogInterval = 60 * time.Second
+	progressInterval      = 5 * time.Second
 )
 
 var _ Executor = &LocalExecutor{}
@@ -41,6 +46,11 @@ func (*LocalExecutor) Execf(logger *logger.Logger, s string, args ...any) ([]str
 	return Execf(logger, s, args...)
 }
 
+// Command returns the given command, unstarted.
+func (*LocalExecutor) Command(cmd ...string) *exec.Cmd {
+	return exec.Command(cmd[0], cmd[1:]...)
+}
+
 // Exec runs the given command, returning its stdout and stderr as a combined
 // slice of lines.
 func Exec(logger *logger.Logger, args ...string) ([]string, error) {
@@ -87,15 +97,25 @@ func (oc *outputCollector) Write(bs []byte) (int, error) {
 // It's expected that this is a long running process, taking hours or more.
 // The process can be canceled gracefully using the passed-in context.
 // While the process runs, we log details each minute about the throughput of
-// the pipe.
-func Pipe(ctx context.Context, logger *logger.Logger, size int64, from, to *exec.Cmd) error {
+// the pipe, and pass structured progress to `report` (if non-nil) every few
+// seconds.
+//
+// Pipe hashes the stream as it is sent. If `to` reports the checksum of the
+// stream it received (see Receive), the two are compared, and a mismatch is
+// an error. The verified checksum is returned; it's empty if `to` didn't
+// report one.
+func Pipe(ctx context.Context, logger *logger.Logger, size int64, from, to *exec.Cmd, report func(model.TransferProgress)) (string, error) {
 	logger.Printf("%s | %s", strings.Join(from.Args, " "), strings.Join(to.Args, " "))
 
 	throughputStat := NewThroughputStat(logger, size)
 	defer throughputStat.Log()
+	if report != nil {
+		defer func() { report(throughputStat.Progress()) }()
+	}
 
+	hash := sha256.New()
 	pw, pr := io.Pipe()
-	tee := io.TeeReader(pw, throughputStat)
+	tee := io.TeeReader(pw, io.MultiWriter(throughputStat, hash))
 	from.Stdout = pr
 	to.Stdin = tee
 
@@ -105,7 +125,7 @@ func Pipe(ctx context.Context, logger *logger.Logger, size int64, from, to *exec
 
 	// Start the `to` command.
 	if err := to.Start(); err != nil {
-		return fmt.Errorf("failed to start 'to' command: %w", err)
+		return "", fmt.Errorf("failed to start 'to' command: %w", err)
 	}
 
 	// Start the `from` command. If we fail to start it, kill the `to`
@@ -115,16 +135,19 @@ func Pipe(ctx context.Context, logger *logger.Logger, size int64, from, to *exec
 		pw.Close()
 		to.Process.Kill()
 		to.Wait()
-		return fmt.Errorf("failed to start 'from' command: %w", err)
+		return "", fmt.Errorf("failed to start 'from' command: %w", err)
 	}
 
 	g, ctx := errgroup.WithContext(ctx)
 	ctx, cancel := context.WithCancel(ctx)
 
-	// Log every $interval until the context is canceled.
+	// Log every $interval until the context is canceled, reporting
+	// progress more frequently in between.
 	g.Go(func() error {
 		ticker := time.NewTicker(throughputLogInterval)
 		defer ticker.Stop()
+		progressTicker := time.NewTicker(progressInterval)
+		defer progressTicker.Stop()
 
 		for {
 			select {
@@ -133,6 +156,10 @@ func Pipe(ctx context.Context, logger *logger.Logger, size int64, from, to *exec
 				return nil
 			case <-ticker.C:
 				throughputStat.Log()
+			case <-progressTicker.C:
+				if report != nil {
+					report(throughputStat.Progress())
+				}
 			}
 		}
 	})
@@ -195,9 +222,55 @@ func Pipe(ctx context.Context, logger *logger.Logger, size int64, from, to *exec
 		from.Process.Kill()
 		to.Process.Kill()
 
-		return fmt.Errorf("process error: %w", err)
+		return "", fmt.Errorf("process error: %w", err)
+	}
+
+	sent := hex.EncodeToString(hash.Sum(nil))
+	received := parseChecksum(out.buf.Bytes())
+	if received == "" {
+		return "", nil
+	}
+	if received != sent {
+		return "", fmt.Errorf("checksum mismatch: sent sha256 %s, received sha256 %s", sent, received)
 	}
+	logger.Printf("verified sha256 %s", sent)
 
+	return sent, nil
+}
+
+// checksumPrefix marks the line of Receive's output that reports the
+// checksum of the received stream.
+const checksumPrefix = "backupd-sha256 "
+
+// parseChecksum finds the checksum reported by Receive in the given output,
+// returning "" if there is none.
+func parseChecksum(output []byte) string {
+	var checksum string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if rest, ok := strings.CutPrefix(scanner.Text(), checksumPrefix); ok {
+			checksum = strings.TrimSpace(rest)
+		}
+	}
+	return checksum
+}
+
+// Receive runs `zfs receive` with the given arguments, feeding it stdin.
+// Once the receive succeeds, it writes the sha256 of everything it read to
+// stdout, for Pipe to verify on the sending side. This is meant to be run on
+// the remote in place of plain `zfs receive`.
+func Receive(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	hash := sha256.New()
+
+	cmd := exec.CommandContext(ctx, "zfs", append([]string{"receive"}, args...)...)
+	cmd.Stdin = io.TeeReader(stdin, hash)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("zfs receive: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "%s%s\n", checksumPrefix, hex.EncodeToString(hash.Sum(nil)))
 	return nil
 }
 
@@ -296,6 +369,48 @@ func (s *ThroughputStat) Log() {
 	)
 }
 
+// Progress returns a structured snapshot of the transfer's progress.
+func (s *ThroughputStat) Progress() model.TransferProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	oneMinuteAgo := now.Add(-time.Minute)
+
+	minuteBytes := int64(0)
+	var firstMinuteTimestamp *time.Time
+	for _, point := range s.dataPoints {
+		if point.timestamp.After(oneMinuteAgo) {
+			minuteBytes += point.bytes
+			if firstMinuteTimestamp == nil || point.timestamp.Before(*firstMinuteTimestamp) {
+				firstMinuteTimestamp = &point.timestamp
+			}
+		}
+	}
+
+	progress := model.TransferProgress{
+		BytesDone:  s.bytesTransferred,
+		TotalBytes: s.size,
+		UpdatedAt:  now,
+	}
+	if elapsed := getElapsedSeconds(&now, firstMinuteTimestamp, 60); elapsed > 0 {
+		progress.CurrentRate = float64(minuteBytes) / float64(elapsed)
+	}
+	if elapsed := now.Sub(s.startedAt).Seconds(); elapsed > 0 {
+		progress.AverageRate = float64(s.bytesTransferred) / elapsed
+	}
+
+	rate := progress.CurrentRate
+	if rate == 0 {
+		rate = progress.AverageRate
+	}
+	if remaining := s.size - s.bytesTransferred; remaining > 0 && rate > 0 {
+		progress.ETA = time.Duration(float64(remaining) / rate * float64(time.Second))
+	}
+
+	return progress
+}
+
 // printThroughput calculates and returns the human-readable network throughput given
 // the amount of data transferred in bytes and the duration of the transfer in seconds.
 // If the duration is zero, it returns the humanized byte size directly to avoid division by zero.
@@ -0,0 +1,56 @@
+package env
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"monks.co/backupd/logger"
+)
+
+// sha256 of "hello\n"
+const helloChecksum = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+func TestPipe_Checksum(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		receiver string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "no checksum reported",
+			receiver: "cat >/dev/null",
+			want:     "",
+		},
+		{
+			name:     "matching checksum",
+			receiver: "cat >/dev/null; echo " + checksumPrefix + helloChecksum,
+			want:     helloChecksum,
+		},
+		{
+			name:     "mismatched checksum",
+			receiver: "cat >/dev/null; echo " + checksumPrefix + strings.Repeat("0", 64),
+			wantErr:  "checksum mismatch",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			from := exec.Command("echo", "hello")
+			to := exec.Command("sh", "-c", tc.receiver)
+			got, err := Pipe(context.Background(), logger.New("test"), 6, from, to, nil)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected checksum %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
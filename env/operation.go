@@ -8,7 +8,10 @@ import (
 	"monks.co/backupd/model"
 )
 
-func (env *Env) Apply(ctx context.Context, logger *logger.Logger, op model.Operation) error {
+// Apply performs the operation against the zfs environment. For transfers,
+// progress is passed to `report`, which may be nil, and the verified
+// checksum of the stream is returned if checksum verification is enabled.
+func (env *Env) Apply(ctx context.Context, logger *logger.Logger, op model.Operation, report func(model.TransferProgress)) (string, error) {
 	// Unwrap PlanStep if necessary
 	if step, ok := op.(*model.PlanStep); ok {
 		op = step.Operation
@@ -24,12 +27,12 @@ func (env *Env) Apply(ctx context.Context, logger *logger.Logger, op model.Opera
 		case model.Remote:
 			target = env.Remote
 		default:
-			return fmt.Errorf("invalid location '%s'", op.Location)
+			return "", fmt.Errorf("invalid location '%s'", op.Location)
 		}
 		if err := target.DestroySnapshot(logger, op.Snapshot.Dataset, op.Snapshot.Name); err != nil {
-			return err
+			return "", err
 		}
-		return nil
+		return "", nil
 
 	case *model.SnapshotRangeDeletion:
 		var target *ZFS
@@ -39,32 +42,35 @@ func (env *Env) Apply(ctx context.Context, logger *logger.Logger, op model.Opera
 		case model.Remote:
 			target = env.Remote
 		default:
-			return fmt.Errorf("invalid location '%s'", op.Location)
+			return "", fmt.Errorf("invalid location '%s'", op.Location)
 		}
 		if err := target.DestroySnapshotRange(logger, op.Start.Dataset, op.Start.Name, op.End.Name); err != nil {
-			return err
+			return "", err
 		}
-		return nil
+		return "", nil
 
-	case *model.InitialSnapshotTransfer:
-		if err := env.TransferInitialSnapshot(ctx, logger, op.Snapshot.Dataset, op.Snapshot.Name); err != nil {
-			return err
+	case *model.RemoteDatasetSetAside:
+		if err := env.Remote.RenameDataset(logger, op.Dataset, op.To); err != nil {
+			return "", err
 		}
-		return nil
+		return "", nil
+
+	case *model.InitialSnapshotTransfer:
+		return env.TransferInitialSnapshot(ctx, logger, op.Snapshot.Dataset, op.Snapshot.Name, report)
 
 	case *model.SnapshotTransfer:
-		if err := env.TransferSnapshot(ctx, logger, op.Snapshot.Dataset, op.Snapshot.Name); err != nil {
-			return err
-		}
-		return nil
+		return env.TransferSnapshot(ctx, logger, op.Snapshot.Dataset, op.Snapshot.Name, report)
 
 	case *model.SnapshotRangeTransfer:
-		if err := env.TransferSnapshotIncrementally(ctx, logger, op.Start.Dataset, op.Start.Name, op.End.Name); err != nil {
-			return err
-		}
-		return nil
+		return env.TransferSnapshotIncrementally(ctx, logger, op.Start.Dataset, op.Start.Name, op.End.Name, report)
+
+	case *model.InitialSnapshotRestore:
+		return env.RestoreInitialSnapshot(ctx, logger, op.Snapshot.Dataset, op.Snapshot.Name, op.To, report)
+
+	case *model.SnapshotRangeRestore:
+		return env.RestoreSnapshotIncrementally(ctx, logger, op.Start.Dataset, op.Start.Name, op.End.Name, op.To, report)
 
 	default:
-		return fmt.Errorf("%s is not supported", op)
+		return "", fmt.Errorf("%s is not supported", op)
 	}
 }
@@ -2,11 +2,24 @@ package env
 
 import (
 	"fmt"
+	"os/exec"
 	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	"monks.co/backupd/logger"
 )
 
+// sshLatency is how long commands run over SSH take, from connecting to
+// exiting, by command, e.g. "zfs list". Streams aren't included.
+var sshLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "backupd_ssh_command_duration_seconds",
+	Help:    "Time taken by commands run over SSH, by command.",
+	Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+}, []string{"command"})
+
 var _ Executor = &Remote{}
 
 type Remote struct {
@@ -19,9 +32,22 @@ func NewRemote(sshKey, sshHost string) *Remote {
 }
 
 func (remote *Remote) Exec(logger *logger.Logger, cmd ...string) ([]string, error) {
-	return Exec(logger, "ssh", "-i", remote.sshKey, remote.sshHost, strings.Join(cmd, " "))
+	return remote.exec(logger, strings.Join(cmd, " "))
 }
 
 func (remote *Remote) Execf(logger *logger.Logger, s string, args ...any) ([]string, error) {
-	return Exec(logger, "ssh", "-i", remote.sshKey, remote.sshHost, fmt.Sprintf(s, args...))
+	return remote.exec(logger, fmt.Sprintf(s, args...))
+}
+
+func (remote *Remote) exec(logger *logger.Logger, cmd string) ([]string, error) {
+	started := time.Now()
+	defer func() {
+		fields := strings.Fields(cmd)
+		sshLatency.WithLabelValues(strings.Join(fields[:min(2, len(fields))], " ")).Observe(time.Since(started).Seconds())
+	}()
+	return Exec(logger, "ssh", "-i", remote.sshKey, remote.sshHost, cmd)
+}
+
+func (remote *Remote) Command(cmd ...string) *exec.Cmd {
+	return exec.Command("ssh", "-i", remote.sshKey, remote.sshHost, strings.Join(cmd, " "))
 }
@@ -0,0 +1,126 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"time"
+
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+	"monks.co/backupd/seed"
+)
+
+// ExportSeed writes a full raw send of a local snapshot into a new seed
+// directory, split into chunks of chunkSize bytes.
+func (env *Env) ExportSeed(ctx context.Context, logger *logger.Logger, dataset model.DatasetName, snapshot string, dir string, chunkSize int64) (*seed.Manifest, error) {
+	sendArgs := []string{"--raw",
+		fmt.Sprintf("%s@%s", env.Local.WithPrefix(dataset), snapshot)}
+	send := env.Local.Send(sendArgs...)
+
+	size, err := env.Local.Size(logger, sendArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("getting size of '%s': %w", snapshot, err)
+	}
+
+	w, err := seed.Create(dir, dataset, snapshot, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	throughputStat := NewThroughputStat(logger, size)
+	defer throughputStat.Log()
+	send.Stdout = io.MultiWriter(w, throughputStat)
+
+	logger.Printf("%s > %s", send, dir)
+	if err := runWithProgress(ctx, send, throughputStat); err != nil {
+		abort(logger, w)
+		return nil, err
+	}
+
+	manifest, err := w.Close()
+	if err != nil {
+		abort(logger, w)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// abort removes a seed whose export failed.
+func abort(logger *logger.Logger, w *seed.Writer) {
+	if err := w.Abort(); err != nil {
+		logger.Printf("removing incomplete seed: %v", err)
+	}
+}
+
+// ImportSeed verifies the seed in dir and receives it into its dataset under
+// zfs's root, creating parent datasets as needed. It's meant to be run on
+// the remote, with zfs configured with the remote root.
+func ImportSeed(ctx context.Context, logger *logger.Logger, zfs *ZFS, dir string) (*seed.Manifest, error) {
+	manifest, err := seed.ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Printf("verifying %d chunks of %s@%s", len(manifest.Chunks), manifest.Dataset, manifest.Snapshot)
+	if err := seed.Verify(dir, manifest); err != nil {
+		return nil, fmt.Errorf("verifying seed: %w", err)
+	}
+
+	if parent := path.Dir(manifest.Dataset.Path()); parent != "." && parent != "/" {
+		if err := zfs.CreateDataset(logger, model.DatasetName(parent)); err != nil {
+			return nil, fmt.Errorf("creating parent dataset '%s': %w", parent, err)
+		}
+	}
+
+	stream := seed.Open(dir, manifest)
+	defer stream.Close()
+
+	throughputStat := NewThroughputStat(logger, manifest.TotalBytes)
+	defer throughputStat.Log()
+
+	out := &outputCollector{logger, &bytes.Buffer{}}
+	recv := zfs.Receive("-s", zfs.WithPrefix(manifest.Dataset))
+	recv.Stdin = io.TeeReader(stream, throughputStat)
+	recv.Stdout = out
+	recv.Stderr = out
+
+	logger.Printf("%s < %s", recv, dir)
+	if err := runWithProgress(ctx, recv, throughputStat); err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, out.buf.Bytes())
+	}
+
+	return manifest, nil
+}
+
+// runWithProgress runs cmd, logging throughput periodically, and kills it
+// if the context is canceled.
+func runWithProgress(ctx context.Context, cmd *exec.Cmd, throughputStat *ThroughputStat) error {
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting '%s': %w", cmd, err)
+	}
+
+	c := make(chan error, 1)
+	go func() { c <- cmd.Wait() }()
+
+	ticker := time.NewTicker(throughputLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-c:
+			if err != nil {
+				return fmt.Errorf("'%s': %w", cmd, err)
+			}
+			return nil
+		case <-ticker.C:
+			throughputStat.Log()
+		case <-ctx.Done():
+			cmd.Process.Kill()
+			<-c
+			return ctx.Err()
+		}
+	}
+}
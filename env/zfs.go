@@ -16,6 +16,9 @@ const readOnly = false
 type Executor interface {
 	Exec(logger *logger.Logger, cmd ...string) ([]string, error)
 	Execf(logger *logger.Logger, cmd string, args ...any) ([]string, error)
+
+	// Command returns an unstarted command, for use with Pipe.
+	Command(cmd ...string) *exec.Cmd
 }
 
 type ZFS struct {
@@ -50,12 +53,21 @@ func (zfs *ZFS) GetResumeToken(logger *logger.Logger, dataset model.DatasetName)
 	return value, nil
 }
 
-func (zfs *ZFS) Size(logger *logger.Logger, cmd *exec.Cmd) (int64, error) {
-	if cmd.Args[0] != "zfs" || cmd.Args[1] != "send" {
-		return 0, fmt.Errorf("must be a zfs send command")
-	}
+// Send returns an unstarted `zfs send` with the given arguments.
+func (zfs *ZFS) Send(args ...string) *exec.Cmd {
+	return zfs.x.Command(append([]string{"zfs", "send"}, args...)...)
+}
+
+// Receive returns an unstarted `zfs receive` with the given arguments.
+func (zfs *ZFS) Receive(args ...string) *exec.Cmd {
+	return zfs.x.Command(append([]string{"zfs", "receive"}, args...)...)
+}
 
-	args := append(cmd.Args[:], "--dryrun", "--verbose", "--parsable")
+// Size estimates the size of the stream that `zfs send` with the given
+// arguments would produce.
+func (zfs *ZFS) Size(logger *logger.Logger, sendArgs ...string) (int64, error) {
+	args := append([]string{"zfs", "send"}, sendArgs...)
+	args = append(args, "--dryrun", "--verbose", "--parsable")
 	out, err := zfs.x.Exec(logger, args...)
 	if err != nil {
 		return 0, fmt.Errorf("getting size of '%s': %w", strings.Join(args, " "), err)
@@ -123,6 +135,26 @@ func (zfs *ZFS) GetDatasets(logger *logger.Logger) ([]DatasetInfo, error) {
 	return out, nil
 }
 
+// GetWritable returns the datasets under the root whose readonly property
+// is off.
+func (zfs *ZFS) GetWritable(logger *logger.Logger) ([]model.DatasetName, error) {
+	rows, err := zfs.x.Execf(logger, "zfs get -H -r -t filesystem -o name,value readonly %s", zfs.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("zfs get: %w", err)
+	}
+	var out []model.DatasetName
+	for _, row := range rows {
+		cols := strings.Split(row, "\t")
+		if len(cols) != 2 {
+			return nil, fmt.Errorf("expected 2 columns, got %d in row: %s", len(cols), row)
+		}
+		if cols[1] == "off" {
+			out = append(out, zfs.WithoutPrefix(cols[0]))
+		}
+	}
+	return out, nil
+}
+
 func (zfs *ZFS) CreateDataset(logger *logger.Logger, dataset model.DatasetName) error {
 	if zfs.readOnly {
 		panic("read only")
@@ -133,6 +165,16 @@ func (zfs *ZFS) CreateDataset(logger *logger.Logger, dataset model.DatasetName)
 	return nil
 }
 
+func (zfs *ZFS) RenameDataset(logger *logger.Logger, dataset, to model.DatasetName) error {
+	if zfs.readOnly {
+		panic("read only")
+	}
+	if _, err := zfs.x.Execf(logger, "zfs rename %s %s", zfs.WithPrefix(dataset), zfs.WithPrefix(to)); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (zfs *ZFS) CreateSnapshot(logger *logger.Logger, pool string, periodicity string) error {
 	if zfs.readOnly {
 		panic("read only")
@@ -178,15 +220,15 @@ func (zfs *ZFS) DestroySnapshotRange(logger *logger.Logger, dataset model.Datase
 }
 
 func (zfs *ZFS) GetSnapshots(logger *logger.Logger, dataset model.DatasetName) ([]*model.Snapshot, error) {
-	rows, err := zfs.x.Execf(logger, "zfs list -H -p -t snapshot -o name,creation,logicalreferenced -s creation -d 1 %s", zfs.WithPrefix(dataset))
+	rows, err := zfs.x.Execf(logger, "zfs list -H -p -t snapshot -o name,creation,logicalreferenced,used,written,%s -s creation -d 1 %s", PinProperty, zfs.WithPrefix(dataset))
 	if err != nil {
 		return nil, fmt.Errorf("zfs list: %w", err)
 	}
 	snaps := make([]*model.Snapshot, len(rows))
 	for i, row := range rows {
 		cols := strings.Split(row, "\t")
-		if len(cols) != 3 {
-			return nil, fmt.Errorf("expected 3 columns, got %d in row: %s", len(cols), row)
+		if len(cols) != 6 {
+			return nil, fmt.Errorf("expected 6 columns, got %d in row: %s", len(cols), row)
 		}
 
 		seconds, err := strconv.ParseInt(cols[1], 10, 64)
@@ -199,12 +241,91 @@ func (zfs *ZFS) GetSnapshots(logger *logger.Logger, dataset model.DatasetName) (
 			return nil, fmt.Errorf("parsing logicalreferenced '%s': %w", cols[2], err)
 		}
 
+		used, err := strconv.ParseInt(cols[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing used '%s': %w", cols[3], err)
+		}
+
+		written, err := strconv.ParseInt(cols[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing written '%s': %w", cols[4], err)
+		}
+
 		snaps[i] = &model.Snapshot{
 			Dataset:           dataset,
 			Name:              strings.SplitN(cols[0], "@", 2)[1],
 			CreatedAt:         seconds,
 			LogicalReferenced: logicalReferenced,
+			Used:              used,
+			Written:           written,
+			Pinned:            cols[5] == "on",
 		}
 	}
 	return snaps, nil
 }
+
+// PinProperty is the ZFS user property which, set to "on", exempts a
+// snapshot from retention.
+const PinProperty = "backupd:pin"
+
+// SetPinned pins or unpins a snapshot.
+func (zfs *ZFS) SetPinned(logger *logger.Logger, dataset model.DatasetName, snapshot string, pinned bool) error {
+	if zfs.readOnly {
+		panic("read only")
+	}
+	name := fmt.Sprintf("%s@%s", zfs.WithPrefix(dataset), snapshot)
+	if pinned {
+		if _, err := zfs.x.Execf(logger, "zfs set %s=on %s", PinProperty, name); err != nil {
+			return fmt.Errorf("pinning %s: %w", name, err)
+		}
+		return nil
+	}
+	if _, err := zfs.x.Execf(logger, "zfs inherit %s %s", PinProperty, name); err != nil {
+		return fmt.Errorf("unpinning %s: %w", name, err)
+	}
+	return nil
+}
+
+type PoolInfo struct {
+	Name string
+	GUID string
+}
+
+// ListPools returns the imported pools.
+func (zfs *ZFS) ListPools(logger *logger.Logger) ([]PoolInfo, error) {
+	rows, err := zfs.x.Execf(logger, "zpool list -H -o name,guid")
+	if err != nil {
+		return nil, fmt.Errorf("zpool list: %w", err)
+	}
+	var out []PoolInfo
+	for _, row := range rows {
+		cols := strings.Split(row, "\t")
+		if len(cols) != 2 {
+			return nil, fmt.Errorf("expected 2 columns, got %d in row: %s", len(cols), row)
+		}
+		out = append(out, PoolInfo{Name: cols[0], GUID: cols[1]})
+	}
+	return out, nil
+}
+
+// ImportPool imports the pool with the given name or GUID, without mounting
+// its datasets.
+func (zfs *ZFS) ImportPool(logger *logger.Logger, pool string) error {
+	if zfs.readOnly {
+		panic("read only")
+	}
+	if _, err := zfs.x.Execf(logger, "zpool import -N %s", pool); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (zfs *ZFS) ExportPool(logger *logger.Logger, pool string) error {
+	if zfs.readOnly {
+		panic("read only")
+	}
+	if _, err := zfs.x.Execf(logger, "zpool export %s", pool); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package events fans out change notifications to any number of
+// subscribers, such as browsers following the web UI's event stream.
+package events
+
+import (
+	"sync"
+)
+
+// Event types.
+const (
+	// DatasetUpdated means a dataset's inventory, metrics, plan, or sync
+	// status changed.
+	DatasetUpdated = "dataset"
+	// StepStatusChanged means a plan step's status or progress changed.
+	StepStatusChanged = "step"
+	// LogAppended means a line was added to the global log, a dataset's
+	// log, or a step's.
+	LogAppended = "log"
+	// StateChanged means something else changed: restores, the removable
+	// target, or warnings.
+	StateChanged = "state"
+)
+
+// An Event is a typed change notification. Data is encoded as JSON for
+// subscribers.
+type Event struct {
+	Type string
+	Data any
+}
+
+// Buffer is how many events a subscriber may fall behind by before it's
+// dropped. A dropped subscriber's channel is closed; it should resubscribe
+// and reread the state it follows.
+const Buffer = 256
+
+// Broker delivers every published event to every subscriber.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func New() *Broker {
+	return &Broker{subs: map[chan Event]struct{}{}}
+}
+
+// Subscribe returns a channel of events published from now on, and a
+// function that unsubscribes it.
+func (broker *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, Buffer)
+	broker.mu.Lock()
+	broker.subs[ch] = struct{}{}
+	broker.mu.Unlock()
+	return ch, func() { broker.drop(ch) }
+}
+
+// Publish sends the event to every subscriber without waiting; those too
+// far behind to take it are dropped.
+func (broker *Broker) Publish(event Event) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for ch := range broker.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(broker.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribers returns how many subscribers there are.
+func (broker *Broker) Subscribers() int {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	return len(broker.subs)
+}
+
+func (broker *Broker) drop(ch chan Event) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if _, ok := broker.subs[ch]; ok {
+		delete(broker.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBroker(t *testing.T) {
+	broker := New()
+	a, unsubscribeA := broker.Subscribe()
+	b, _ := broker.Subscribe()
+
+	broker.Publish(Event{Type: DatasetUpdated, Data: "/home"})
+	for name, ch := range map[string]<-chan Event{"a": a, "b": b} {
+		if got := <-ch; got.Type != DatasetUpdated || got.Data != "/home" {
+			t.Errorf("subscriber %s got %+v", name, got)
+		}
+	}
+
+	unsubscribeA()
+	if _, ok := <-a; ok {
+		t.Error("expected an unsubscribed channel to be closed")
+	}
+	unsubscribeA() // idempotent
+	if n := broker.Subscribers(); n != 1 {
+		t.Errorf("expected 1 subscriber, got %d", n)
+	}
+
+	// b never reads, so it's dropped once its buffer is full.
+	for range Buffer + 1 {
+		broker.Publish(Event{Type: LogAppended})
+	}
+	if n := broker.Subscribers(); n != 0 {
+		t.Errorf("expected the lagging subscriber to be dropped, got %d subscribers", n)
+	}
+	n := 0
+	for range b {
+		n++
+	}
+	if n != Buffer {
+		t.Errorf("expected the dropped subscriber to get the %d events it had room for, got %d", Buffer, n)
+	}
+}
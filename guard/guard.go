@@ -0,0 +1,278 @@
+// Package guard restricts what an SSH key can run on the backup server (or,
+// in pull mode, the production host) to the zfs commands backupd issues,
+// confined to one root dataset. It's used by `backupd ssh-guard`, as an
+// authorized_keys forced command.
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// Dataset and snapshot names are passed through a shell by ssh, so
+	// anything beyond zfs's ordinary name characters is refused.
+	datasetPattern  = regexp.MustCompile(`^[A-Za-z0-9_.:/-]+$`)
+	snapshotPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+	tokenPattern    = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// ErrNotAllowed is returned for commands that aren't allowed
+var ErrNotAllowed = errors.New("command not allowed")
+
+// A Guard checks commands against the shapes backupd issues to the remote.
+type Guard struct {
+	// Root is the remote root dataset. Every command must name Root or a
+	// dataset beneath it.
+	Root string
+
+	// Helper is the path of the `backupd receive` helper, if receives
+	// through it should be allowed.
+	Helper string
+
+	// ResolveToken returns the dataset a resume token would send from, so
+	// that resumed sends can be confined to Root too. If nil, resumed
+	// sends are refused.
+	ResolveToken func(token string) (string, error)
+}
+
+// Check parses an SSH_ORIGINAL_COMMAND, returning the command to execute if
+// it's allowed.
+func (g *Guard) Check(command string) ([]string, error) {
+	args := strings.Fields(command)
+	if err := g.CheckArgs(args); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// CheckArgs checks a command that's already split into arguments, exactly
+// as it will be executed.
+func (g *Guard) CheckArgs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: empty command", ErrNotAllowed)
+	}
+
+	var err error
+	switch {
+	case g.Helper != "" && args[0] == g.Helper && len(args) > 1 && args[1] == "receive":
+		err = g.checkReceive(args[2:])
+	case args[0] != "zfs" || len(args) < 2:
+		err = fmt.Errorf("%w: not a zfs command", ErrNotAllowed)
+	default:
+		switch args[1] {
+		case "list":
+			err = g.checkList(args[2:])
+		case "get":
+			err = g.checkGet(args[2:])
+		case "receive":
+			if len(args) == 4 && args[2] == "-A" {
+				err = g.checkDataset(args[3])
+			} else {
+				err = g.checkReceive(args[2:])
+			}
+		case "create":
+			err = g.checkCreate(args[2:])
+		case "snapshot":
+			err = g.checkSnapshotCreate(args[2:])
+		case "rename":
+			err = g.checkRename(args[2:])
+		case "destroy":
+			err = g.checkDestroy(args[2:])
+		case "set", "inherit":
+			err = g.checkPin(args[1:])
+		case "send":
+			err = g.checkSend(args[2:])
+		default:
+			err = fmt.Errorf("%w: zfs %s", ErrNotAllowed, args[1])
+		}
+	}
+	return err
+}
+
+// checkDataset checks that name is Root or a dataset beneath it.
+func (g *Guard) checkDataset(name string) error {
+	if !datasetPattern.MatchString(name) {
+		return fmt.Errorf("%w: invalid dataset name '%s'", ErrNotAllowed, name)
+	}
+	if slices.Contains(strings.Split(name, "/"), "..") {
+		return fmt.Errorf("%w: invalid dataset name '%s'", ErrNotAllowed, name)
+	}
+	if name != g.Root && !strings.HasPrefix(name, g.Root+"/") {
+		return fmt.Errorf("%w: '%s' is outside '%s'", ErrNotAllowed, name, g.Root)
+	}
+	return nil
+}
+
+// checkSnapshot checks a `dataset@snapshot` name. If allowRange is set, the
+// snapshot may be a `first%last` range.
+func (g *Guard) checkSnapshot(name string, allowRange bool) error {
+	dataset, snapshot, ok := strings.Cut(name, "@")
+	if !ok {
+		return fmt.Errorf("%w: '%s' is not a snapshot", ErrNotAllowed, name)
+	}
+	if err := g.checkDataset(dataset); err != nil {
+		return err
+	}
+	names := []string{snapshot}
+	if allowRange {
+		if first, last, ok := strings.Cut(snapshot, "%"); ok {
+			names = []string{first, last}
+		}
+	}
+	for _, name := range names {
+		if !snapshotPattern.MatchString(name) {
+			return fmt.Errorf("%w: invalid snapshot name '%s'", ErrNotAllowed, name)
+		}
+	}
+	return nil
+}
+
+// zfs list -H -o receive_resume_token -S name -d 0 <dataset>
+// zfs list -H -p -t filesystem -o name,used,logicalreferenced -d 1000 <root>
+// zfs list -H -p -t snapshot -o name,creation,logicalreferenced,used,written,backupd:pin -s creation -d 1 <dataset>
+func (g *Guard) checkList(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: zfs list without a dataset", ErrNotAllowed)
+	}
+	flags, dataset := strings.Join(args[:len(args)-1], " "), args[len(args)-1]
+	switch flags {
+	case "-H -o receive_resume_token -S name -d 0",
+		"-H -p -t filesystem -o name,used,logicalreferenced -d 1000",
+		"-H -p -t snapshot -o name,creation,logicalreferenced,used,written,backupd:pin -s creation -d 1":
+		return g.checkDataset(dataset)
+	}
+	return fmt.Errorf("%w: zfs list %s", ErrNotAllowed, flags)
+}
+
+// zfs get -H -r -t filesystem -o name,value readonly <dataset>
+//
+// This is only issued to the local side, in pull mode, for replicas.
+func (g *Guard) checkGet(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: zfs get without a dataset", ErrNotAllowed)
+	}
+	flags, dataset := strings.Join(args[:len(args)-1], " "), args[len(args)-1]
+	if flags == "-H -r -t filesystem -o name,value readonly" {
+		return g.checkDataset(dataset)
+	}
+	return fmt.Errorf("%w: zfs get %s", ErrNotAllowed, flags)
+}
+
+// zfs receive -s [-F] <dataset>
+func (g *Guard) checkReceive(args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "-s":
+		return g.checkDataset(args[1])
+	case len(args) == 3 && args[0] == "-s" && args[1] == "-F":
+		return g.checkDataset(args[2])
+	}
+	return fmt.Errorf("%w: receive %s", ErrNotAllowed, strings.Join(args, " "))
+}
+
+// zfs create -p <dataset>
+func (g *Guard) checkCreate(args []string) error {
+	if len(args) == 2 && args[0] == "-p" {
+		return g.checkDataset(args[1])
+	}
+	return fmt.Errorf("%w: zfs create %s", ErrNotAllowed, strings.Join(args, " "))
+}
+
+// zfs snapshot -r <dataset>@<snapshot>
+//
+// This is only issued to the local side, in pull mode.
+func (g *Guard) checkSnapshotCreate(args []string) error {
+	if len(args) == 2 && args[0] == "-r" {
+		return g.checkSnapshot(args[1], false)
+	}
+	return fmt.Errorf("%w: zfs snapshot %s", ErrNotAllowed, strings.Join(args, " "))
+}
+
+// zfs rename <dataset> <dataset>
+func (g *Guard) checkRename(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: zfs rename %s", ErrNotAllowed, strings.Join(args, " "))
+	}
+	for _, arg := range args {
+		if arg == g.Root {
+			return fmt.Errorf("%w: renaming the root", ErrNotAllowed)
+		}
+		if err := g.checkDataset(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zfs destroy <dataset>@<snapshot>
+// zfs destroy <dataset>@<first>%<last>
+//
+// Only snapshots may be destroyed, never datasets.
+func (g *Guard) checkDestroy(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: zfs destroy %s", ErrNotAllowed, strings.Join(args, " "))
+	}
+	return g.checkSnapshot(args[0], true)
+}
+
+// zfs set backupd:pin=on <dataset>@<snapshot>
+// zfs inherit backupd:pin <dataset>@<snapshot>
+func (g *Guard) checkPin(args []string) error {
+	switch {
+	case len(args) == 3 && args[0] == "set" && args[1] == "backupd:pin=on",
+		len(args) == 3 && args[0] == "inherit" && args[1] == "backupd:pin":
+		return g.checkSnapshot(args[2], false)
+	}
+	return fmt.Errorf("%w: zfs %s", ErrNotAllowed, strings.Join(args, " "))
+}
+
+// zfs send --raw <dataset>@<snapshot>
+// zfs send --raw -i <dataset>@<snapshot> <dataset>@<snapshot>
+// zfs send --raw -t <token>
+//
+// each optionally followed by `--dryrun --verbose --parsable`, for size
+// estimates.
+func (g *Guard) checkSend(args []string) error {
+	joined, _ := strings.CutSuffix(strings.Join(args, " "), " --dryrun --verbose --parsable")
+	fields := strings.Fields(joined)
+	switch {
+	case len(fields) == 2 && fields[0] == "--raw":
+		return g.checkSnapshot(fields[1], false)
+	case len(fields) == 4 && fields[0] == "--raw" && fields[1] == "-i":
+		if err := g.checkSnapshot(fields[2], false); err != nil {
+			return err
+		}
+		return g.checkSnapshot(fields[3], false)
+	case len(fields) == 3 && fields[0] == "--raw" && fields[1] == "-t":
+		return g.checkToken(fields[2])
+	}
+	return fmt.Errorf("%w: zfs send %s", ErrNotAllowed, joined)
+}
+
+func (g *Guard) checkToken(token string) error {
+	if !tokenPattern.MatchString(token) {
+		return fmt.Errorf("%w: invalid resume token", ErrNotAllowed)
+	}
+	if g.ResolveToken == nil {
+		return fmt.Errorf("%w: can't resolve resume token", ErrNotAllowed)
+	}
+	name, err := g.ResolveToken(token)
+	if err != nil {
+		return fmt.Errorf("%w: resolving resume token: %w", ErrNotAllowed, err)
+	}
+	return g.checkSnapshot(name, false)
+}
+
+// ParseTokenName finds the snapshot name in the output of `zfs send -nv -t
+// <token>`, which describes the token's contents.
+func ParseTokenName(output string) (string, error) {
+	for line := range strings.Lines(output) {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "toname = "); ok {
+			return name, nil
+		}
+	}
+	return "", errors.New("no toname in resume token")
+}
@@ -0,0 +1,111 @@
+package guard
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	g := &Guard{
+		Root:   "tank/backups",
+		Helper: "backupd",
+		ResolveToken: func(token string) (string, error) {
+			switch token {
+			case "1-inside":
+				return "tank/backups/home@daily-2024-01-01", nil
+			case "1-outside":
+				return "tank/other@daily-2024-01-01", nil
+			}
+			return "", errors.New("bad token")
+		},
+	}
+
+	allowed := []string{
+		"zfs list -H -o receive_resume_token -S name -d 0 tank/backups/home",
+		"zfs list -H -p -t filesystem -o name,used,logicalreferenced -d 1000 tank/backups",
+		"zfs list -H -p -t snapshot -o name,creation,logicalreferenced,used,written,backupd:pin -s creation -d 1 tank/backups/home",
+		"zfs get -H -r -t filesystem -o name,value readonly tank/backups",
+		"zfs set backupd:pin=on tank/backups/home@daily-2024-01-01",
+		"zfs inherit backupd:pin tank/backups/home@daily-2024-01-01",
+		"zfs receive -s tank/backups/home",
+		"zfs receive -s -F tank/backups/home",
+		"backupd receive -s -F tank/backups/home",
+		"zfs receive -A tank/backups/home",
+		"zfs create -p tank/backups/home",
+		"zfs rename tank/backups/home tank/backups/home.orphaned-2024-01-01",
+		"zfs destroy tank/backups/home@daily-2024-01-01-00:00:00",
+		"zfs destroy tank/backups/home@daily-2024-01-01%daily-2024-01-05",
+		"zfs send --raw tank/backups/home@daily-2024-01-01",
+		"zfs send --raw -i tank/backups/home@a tank/backups/home@b --dryrun --verbose --parsable",
+		"zfs send --raw -t 1-inside",
+		"zfs snapshot -r tank/backups@daily-2024-01-01-00:00:00",
+	}
+	for _, cmd := range allowed {
+		if _, err := g.Check(cmd); err != nil {
+			t.Errorf("expected '%s' to be allowed: %v", cmd, err)
+		}
+	}
+
+	refused := []string{
+		"",
+		"sh -c id",
+		"zfs destroy tank/backups/home",
+		"zfs destroy -r tank/backups@daily",
+		"zfs destroy tank/other@daily",
+		"zfs destroy tank/backups/../other@daily",
+		"zfs receive -s tank/backupsx",
+		"zfs receive -s -F tank/other",
+		"zfs receive -s tank/backups/home;rm",
+		"zfs create tank/backups/home",
+		"zfs rename tank/backups tank/elsewhere",
+		"zfs rename tank/backups/home tank/other",
+		"zfs list -H tank",
+		"zfs get -H -r -t filesystem -o name,value readonly tank/other",
+		"zfs get -H -r -o name,value all tank/backups",
+		"zfs set mountpoint=/ tank/backups",
+		"zfs set backupd:pin=on tank/other@daily",
+		"zfs set backupd:pin=on tank/backups/home",
+		"zfs inherit mountpoint tank/backups/home@daily",
+		"zfs send --raw tank/other@daily",
+		"zfs send --raw -t 1-outside",
+		"zfs send --raw -t 1-unknown",
+		"other receive -s tank/backups/home",
+		"zfs snapshot tank/backups@daily",
+		"zfs snapshot -r tank/other@daily",
+	}
+	for _, cmd := range refused {
+		if _, err := g.Check(cmd); !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("expected '%s' to be refused, got %v", cmd, err)
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	g := &Guard{Root: "tank/backups"}
+	if err := g.CheckArgs([]string{"zfs", "receive", "-s", "-F", "tank/backups/home"}); err != nil {
+		t.Errorf("expected the receive to be allowed: %v", err)
+	}
+	// Joined, this would pass as `zfs receive -s -F tank/backups/home`.
+	if err := g.CheckArgs([]string{"zfs", "receive", "-s", "-F tank/backups/home"}); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("expected an argument holding two options to be refused, got %v", err)
+	}
+}
+
+func TestParseTokenName(t *testing.T) {
+	output := `resume token contents:
+nvlist version: 0
+	object = 0x6
+	offset = 0x0
+	bytes = 0x0
+	toguid = 0x5b7e2bc8e1d6c3a
+	toname = tank/backups/home@daily-2024-01-01
+send from tank/backups/home@daily-2024-01-01 estimated size is 1.2G
+`
+	name, err := ParseTokenName(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "tank/backups/home@daily-2024-01-01" {
+		t.Fatalf("got %s", name)
+	}
+}
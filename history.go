@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"monks.co/backupd/history"
+)
+
+// historyLimit is how many cycles are listed by default.
+const historyLimit = 50
+
+// finishCycle ends and saves the cycle. Without an error, its status sums
+// up its datasets'.
+func (b *Backupd) finishCycle(cycle *history.Cycle, logsFrom int, err error) {
+	status := history.StatusOK
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = history.StatusInterrupted
+	case err != nil, cycle.Count(history.StatusFailed) > 0:
+		status = history.StatusFailed
+	case cycle.Count(history.StatusHeld) > 0:
+		status = history.StatusHeld
+	}
+	cycle.Finish(status, err)
+	if logs := b.globalLogs.GetLogs(); logsFrom <= len(logs) {
+		cycle.Logs = slices.Clone(logs[logsFrom:])
+	}
+	b.saveCycle(cycle)
+}
+
+func (b *Backupd) saveCycle(cycle *history.Cycle) {
+	if b.history == nil {
+		return
+	}
+	if err := b.history.Save(cycle); err != nil {
+		b.globalLogs.Printf("saving history: %v", err)
+	}
+}
+
+// historyView is what the history page shows: recent cycles, optionally
+// only those syncing one dataset, or a single cycle in detail.
+type historyView struct {
+	Cycles  []*history.Cycle
+	Cycle   *history.Cycle
+	Dataset string
+	Error   string
+}
+
+// loadHistory reads the history page's cycles for the request's query.
+func (b *Backupd) loadHistory(req *http.Request) *historyView {
+	view := &historyView{Dataset: req.URL.Query().Get("dataset")}
+	if b.history == nil {
+		view.Error = "History isn't recorded by this process."
+		return view
+	}
+
+	var err error
+	if id := req.URL.Query().Get("cycle"); id != "" {
+		n, parseErr := strconv.ParseInt(id, 10, 64)
+		if parseErr != nil {
+			view.Error = "Invalid cycle " + id
+			return view
+		}
+		view.Cycle, err = b.history.Get(n)
+	} else {
+		view.Cycles, err = b.history.List(view.Dataset, historyLimit)
+	}
+	if err != nil {
+		view.Error = err.Error()
+	}
+	return view
+}
+
+// serveCycles serves recorded cycles as JSON: one, by ?id=, or a list,
+// newest first, optionally filtered by ?dataset= and limited by ?limit=.
+func (b *Backupd) serveCycles(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if b.history == nil {
+		http.Error(w, "History isn't recorded by this process", http.StatusNotFound)
+		return
+	}
+
+	query := req.URL.Query()
+	var body any
+	if query.Has("id") {
+		id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+			return
+		}
+		cycle, err := b.history.Get(id)
+		if errors.Is(err, history.ErrNotFound) {
+			http.Error(w, "No such cycle", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = cycle
+	} else {
+		limit := historyLimit
+		if query.Has("limit") {
+			n, err := strconv.Atoi(query.Get("limit"))
+			if err != nil || n < 1 {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		cycles, err := b.history.List(query.Get("dataset"), limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = cycles
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		b.globalLogs.Printf("writing history: %v", err)
+	}
+}
@@ -0,0 +1,480 @@
+// Package history records each sync cycle, and the plan steps executed in
+// it, as JSON files in the state directory, so that step durations, errors,
+// and logs outlive the cycle and the process. It also keeps what's known of
+// a removable target's drives, which must outlive the process too.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+)
+
+// Keep is how many cycles are kept; older ones are deleted. At one cycle
+// an hour, that's about six weeks.
+const Keep = 1000
+
+// Status is the outcome of a cycle, a dataset's part in one, or a step.
+type Status string
+
+const (
+	StatusRunning     Status = "running"
+	StatusOK          Status = "ok"
+	StatusFailed      Status = "failed"
+	StatusHeld        Status = "held"
+	StatusInterrupted Status = "interrupted"
+)
+
+// A Cycle is one pass of the sync loop over every dataset.
+type Cycle struct {
+	ID         int64             `json:"id"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Status     Status            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	DryRun     bool              `json:"dryrun,omitempty"`
+	Datasets   []*DatasetRun     `json:"datasets"`
+	Logs       []logger.LogEntry `json:"logs,omitempty"`
+}
+
+// A DatasetRun is the syncing of one dataset within a cycle.
+type DatasetRun struct {
+	Dataset string            `json:"dataset"`
+	Status  Status            `json:"status"`
+	Error   string            `json:"error,omitempty"`
+	Steps   []*Step           `json:"steps"`
+	Logs    []logger.LogEntry `json:"logs,omitempty"`
+}
+
+// A Step is an executed plan step.
+type Step struct {
+	Operation string            `json:"operation"`
+	Kind      string            `json:"kind"`
+	Status    Status            `json:"status"`
+	StartedAt time.Time         `json:"started_at"`
+	StoppedAt time.Time         `json:"stopped_at"`
+	Bytes     int64             `json:"bytes,omitempty"`
+	Checksum  string            `json:"checksum,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Logs      []logger.LogEntry `json:"logs,omitempty"`
+}
+
+// NewCycle starts a cycle. It has no ID until it's first saved.
+func NewCycle(dryrun bool) *Cycle {
+	return &Cycle{
+		StartedAt: time.Now(),
+		Status:    StatusRunning,
+		DryRun:    dryrun,
+		Datasets:  []*DatasetRun{},
+	}
+}
+
+// Finish ends the cycle with the given status and error, if any.
+func (cycle *Cycle) Finish(status Status, err error) {
+	now := time.Now()
+	cycle.FinishedAt = &now
+	cycle.Status = status
+	if err != nil {
+		cycle.Error = err.Error()
+	}
+}
+
+// Duration returns how long the cycle ran, or has been running.
+func (cycle *Cycle) Duration() time.Duration {
+	if cycle.FinishedAt == nil {
+		return time.Since(cycle.StartedAt)
+	}
+	return cycle.FinishedAt.Sub(cycle.StartedAt)
+}
+
+// StartDataset adds a dataset's run to the cycle.
+func (cycle *Cycle) StartDataset(dataset model.DatasetName) *DatasetRun {
+	run := &DatasetRun{Dataset: dataset.String(), Status: StatusRunning, Steps: []*Step{}}
+	cycle.Datasets = append(cycle.Datasets, run)
+	return run
+}
+
+// Dataset returns the dataset's run in the cycle, or nil.
+func (cycle *Cycle) Dataset(dataset string) *DatasetRun {
+	for _, run := range cycle.Datasets {
+		if run.Dataset == dataset {
+			return run
+		}
+	}
+	return nil
+}
+
+// Count returns how many of the cycle's datasets finished with the status.
+func (cycle *Cycle) Count(status Status) int {
+	var n int
+	for _, run := range cycle.Datasets {
+		if run.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// StepCount returns how many steps the cycle executed.
+func (cycle *Cycle) StepCount() int {
+	var n int
+	for _, run := range cycle.Datasets {
+		n += len(run.Steps)
+	}
+	return n
+}
+
+// Bytes returns how many bytes the cycle's steps transferred.
+func (cycle *Cycle) Bytes() int64 {
+	var n int64
+	for _, run := range cycle.Datasets {
+		for _, step := range run.Steps {
+			n += step.Bytes
+		}
+	}
+	return n
+}
+
+// Finish ends the dataset's run with the given status and error, if any.
+func (run *DatasetRun) Finish(status Status, err error) {
+	run.Status = status
+	if err != nil {
+		run.Error = err.Error()
+	}
+}
+
+// AddStep records an executed plan step, and the error it failed with, if
+// any.
+func (run *DatasetRun) AddStep(step *model.PlanStep, err error) {
+	record := &Step{
+		Operation: step.String(),
+		Kind:      model.OperationKind(step),
+		Status:    StatusOK,
+		Checksum:  step.Checksum,
+	}
+	if step.StartedAt != nil {
+		record.StartedAt = *step.StartedAt
+	}
+	if step.StoppedAt != nil {
+		record.StoppedAt = *step.StoppedAt
+	}
+	if step.Progress != nil {
+		record.Bytes = step.Progress.BytesDone
+	}
+	if step.Logs != nil {
+		record.Logs = step.Logs.GetLogs()
+	}
+	if err != nil {
+		record.Status = StatusFailed
+		record.Error = err.Error()
+	}
+	run.Steps = append(run.Steps, record)
+}
+
+// Duration returns how long the step ran.
+func (step *Step) Duration() time.Duration {
+	return step.StoppedAt.Sub(step.StartedAt)
+}
+
+// MarshalJSON adds the duration, in seconds, for consumers that don't want
+// to parse timestamps.
+func (step *Step) MarshalJSON() ([]byte, error) {
+	type plain Step
+	return json.Marshal(struct {
+		*plain
+		DurationSeconds float64 `json:"duration_seconds"`
+	}{(*plain)(step), step.Duration().Seconds()})
+}
+
+// ErrNotFound is returned by Get for cycles that aren't in the store.
+var ErrNotFound = errors.New("no such cycle")
+
+// Store keeps cycles as one JSON file each, named by ID. Files are replaced
+// atomically, so readers never see a partial write.
+type Store struct {
+	dir string
+
+	mu     sync.Mutex
+	nextID int64
+}
+
+// Open opens the store in dir, creating it if need be. Cycles left running
+// by a previous process are marked interrupted.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating history dir: %w", err)
+	}
+	store := &Store{dir: dir, nextID: 1}
+
+	ids, err := store.ids()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		store.nextID = max(store.nextID, id+1)
+	}
+	for _, id := range ids {
+		cycle, err := store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if cycle.Status != StatusRunning {
+			continue
+		}
+		// It finished, as far as anyone can tell, when its last step
+		// stopped.
+		finished := cycle.StartedAt
+		cycle.Status = StatusInterrupted
+		for _, run := range cycle.Datasets {
+			if run.Status == StatusRunning {
+				run.Status = StatusInterrupted
+			}
+			for _, step := range run.Steps {
+				if step.StoppedAt.After(finished) {
+					finished = step.StoppedAt
+				}
+			}
+		}
+		cycle.FinishedAt = &finished
+		if err := store.Save(cycle); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Save writes the cycle, assigning it an ID if it's new. Saving a new cycle
+// deletes those beyond Keep.
+func (store *Store) Save(cycle *Cycle) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	isNew := cycle.ID == 0
+	if isNew {
+		cycle.ID = store.nextID
+		store.nextID++
+	}
+
+	data, err := json.MarshalIndent(cycle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding cycle %d: %w", cycle.ID, err)
+	}
+	if err := store.write(store.path(cycle.ID), data); err != nil {
+		return fmt.Errorf("saving cycle %d: %w", cycle.ID, err)
+	}
+
+	if isNew {
+		return store.prune()
+	}
+	return nil
+}
+
+// write replaces the file at path with data atomically.
+func (store *Store) write(path string, data []byte) error {
+	tmp, err := os.CreateTemp(store.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+const targetFile = "target.json"
+
+// SaveTarget records the removable target, so that the inventories of its
+// drives that are away, and the bases kept for them, survive a restart.
+func (store *Store) SaveTarget(target *model.Target) error {
+	data, err := json.MarshalIndent(target, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding target: %w", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.write(filepath.Join(store.dir, targetFile), data); err != nil {
+		return fmt.Errorf("saving target: %w", err)
+	}
+	return nil
+}
+
+// LoadTarget reads the removable target recorded by SaveTarget, or returns
+// nil if none was.
+func (store *Store) LoadTarget() (*model.Target, error) {
+	data, err := os.ReadFile(filepath.Join(store.dir, targetFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading target: %w", err)
+	}
+	var target model.Target
+	if err := json.Unmarshal(data, &target); err != nil {
+		return nil, fmt.Errorf("decoding target: %w", err)
+	}
+	if target.Drives == nil {
+		target.Drives = map[string]*model.TargetDrive{}
+	}
+	return &target, nil
+}
+
+const warningsFile = "warnings.json"
+
+// SaveWarnings records the warnings raised so far, so that they survive a
+// restart until someone dismisses them.
+func (store *Store) SaveWarnings(warnings []string) error {
+	data, err := json.MarshalIndent(warnings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding warnings: %w", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.write(filepath.Join(store.dir, warningsFile), data); err != nil {
+		return fmt.Errorf("saving warnings: %w", err)
+	}
+	return nil
+}
+
+// LoadWarnings reads the warnings recorded by SaveWarnings, if any.
+func (store *Store) LoadWarnings() ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(store.dir, warningsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading warnings: %w", err)
+	}
+	var warnings []string
+	if err := json.Unmarshal(data, &warnings); err != nil {
+		return nil, fmt.Errorf("decoding warnings: %w", err)
+	}
+	return warnings, nil
+}
+
+const approvalsFile = "approvals.json"
+
+// SaveApprovals records the fingerprints of the approved plans that haven't
+// run yet, so that an approval survives a restart.
+func (store *Store) SaveApprovals(approvals map[model.DatasetName]string) error {
+	data, err := json.MarshalIndent(approvals, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding approvals: %w", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.write(filepath.Join(store.dir, approvalsFile), data); err != nil {
+		return fmt.Errorf("saving approvals: %w", err)
+	}
+	return nil
+}
+
+// LoadApprovals reads the approvals recorded by SaveApprovals, if any.
+func (store *Store) LoadApprovals() (map[model.DatasetName]string, error) {
+	data, err := os.ReadFile(filepath.Join(store.dir, approvalsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[model.DatasetName]string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading approvals: %w", err)
+	}
+	approvals := map[model.DatasetName]string{}
+	if err := json.Unmarshal(data, &approvals); err != nil {
+		return nil, fmt.Errorf("decoding approvals: %w", err)
+	}
+	return approvals, nil
+}
+
+// Get reads one cycle.
+func (store *Store) Get(id int64) (*Cycle, error) {
+	data, err := os.ReadFile(store.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("reading cycle %d: %w", id, err)
+	}
+	var cycle Cycle
+	if err := json.Unmarshal(data, &cycle); err != nil {
+		return nil, fmt.Errorf("decoding cycle %d: %w", id, err)
+	}
+	return &cycle, nil
+}
+
+// List reads up to limit cycles, newest first. If dataset is set, only
+// cycles in which that dataset was synced are included.
+func (store *Store) List(dataset string, limit int) ([]*Cycle, error) {
+	ids, err := store.ids()
+	if err != nil {
+		return nil, err
+	}
+	cycles := []*Cycle{}
+	for _, id := range slices.Backward(ids) {
+		if len(cycles) >= limit {
+			break
+		}
+		cycle, err := store.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			continue // pruned since listing
+		} else if err != nil {
+			return nil, err
+		}
+		if dataset != "" && cycle.Dataset(dataset) == nil {
+			continue
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles, nil
+}
+
+func (store *Store) path(id int64) string {
+	return filepath.Join(store.dir, fmt.Sprintf("cycle-%010d.json", id))
+}
+
+// ids lists the stored cycles' IDs in ascending order.
+func (store *Store) ids() ([]int64, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing history: %w", err)
+	}
+	var ids []int64
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "cycle-")
+		if !ok {
+			continue
+		}
+		name, ok = strings.CutSuffix(name, ".json")
+		if !ok {
+			continue
+		}
+		if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (store *Store) prune() error {
+	ids, err := store.ids()
+	if err != nil {
+		return err
+	}
+	for len(ids) > Keep {
+		if err := os.Remove(store.path(ids[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("pruning history: %w", err)
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cycle := NewCycle(false)
+	run := cycle.StartDataset("/home")
+	step := model.NewPlanStep(&model.SnapshotDeletion{
+		Location: model.Remote,
+		Snapshot: &model.Snapshot{Dataset: "/home", Name: "daily-2024-01-01"},
+	})
+	started := time.Now()
+	stopped := started.Add(3 * time.Second)
+	step.StartedAt, step.StoppedAt = &started, &stopped
+	step.Logs.Printf("destroying")
+	run.AddStep(step, errors.New("dataset is busy"))
+	run.Finish(StatusFailed, errors.New("dataset is busy"))
+	if err := store.Save(cycle); err != nil {
+		t.Fatal(err)
+	}
+	if cycle.ID != 1 {
+		t.Errorf("expected the first cycle to be #1, got #%d", cycle.ID)
+	}
+
+	got, err := store.Get(cycle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusRunning {
+		t.Errorf("status: got %s, expected running", got.Status)
+	}
+	gotStep := got.Dataset("/home").Steps[0]
+	if gotStep.Kind != "deletion" || gotStep.Status != StatusFailed || gotStep.Error != "dataset is busy" {
+		t.Errorf("unexpected step: %+v", gotStep)
+	}
+	if gotStep.Duration() != 3*time.Second {
+		t.Errorf("duration: got %s, expected 3s", gotStep.Duration())
+	}
+	if len(gotStep.Logs) != 1 || gotStep.Logs[0].Log != "destroying" {
+		t.Errorf("unexpected step logs: %+v", gotStep.Logs)
+	}
+
+	// A new process finds the cycle left running, and numbers on from it.
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = store.Get(cycle.ID); err != nil {
+		t.Fatal(err)
+	} else if got.Status != StatusInterrupted || got.Datasets[0].Status != StatusFailed {
+		t.Errorf("expected an interrupted cycle with a failed dataset, got %s and %s", got.Status, got.Datasets[0].Status)
+	} else if got.FinishedAt == nil || !got.FinishedAt.Equal(stopped) {
+		t.Errorf("expected the interrupted cycle to finish with its last step, at %s, got %v", stopped, got.FinishedAt)
+	}
+
+	next := NewCycle(false)
+	next.StartDataset("/var")
+	next.Finish(StatusOK, nil)
+	if err := store.Save(next); err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 2 {
+		t.Errorf("expected the next cycle to be #2, got #%d", next.ID)
+	}
+
+	cycles, err := store.List("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cycles) != 2 || cycles[0].ID != 2 || cycles[1].ID != 1 {
+		t.Errorf("expected cycles 2 and 1, got %d cycles", len(cycles))
+	}
+	if cycles, err = store.List("/home", 10); err != nil {
+		t.Fatal(err)
+	} else if len(cycles) != 1 || cycles[0].ID != 1 {
+		t.Errorf("expected only cycle 1 to include /home, got %d cycles", len(cycles))
+	}
+
+	if _, err := store.Get(3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	// Pretend the store is full.
+	for id := 1; id <= Keep; id++ {
+		if err := os.WriteFile(fmt.Sprintf("%s/cycle-%010d.json", dir, id), []byte(`{"status":"ok"}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cycle := NewCycle(false)
+	if err := store.Save(cycle); err != nil {
+		t.Fatal(err)
+	}
+	if cycle.ID != Keep+1 {
+		t.Errorf("expected cycle #%d, got #%d", Keep+1, cycle.ID)
+	}
+	if _, err := store.Get(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the oldest cycle to be pruned, got %v", err)
+	}
+	if _, err := store.Get(2); err != nil {
+		t.Errorf("expected the second cycle to be kept, got %v", err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings, err := store.LoadWarnings(); err != nil || len(warnings) != 0 {
+		t.Fatalf("expected no warnings before any are saved, got %v, %v", warnings, err)
+	}
+	if err := store.SaveWarnings([]string{"interrupted operation"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// As after a restart.
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings, err := store.LoadWarnings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0] != "interrupted operation" {
+		t.Errorf("expected the saved warning, got %v", warnings)
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approvals, err := store.LoadApprovals(); err != nil || len(approvals) != 0 {
+		t.Fatalf("expected no approvals before any are saved, got %v, %v", approvals, err)
+	}
+	if err := store.SaveApprovals(map[model.DatasetName]string{"tank/home": "3f9a0c2e1b7d4a65"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// As after a restart.
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approvals, err := store.LoadApprovals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 1 || approvals["tank/home"] != "3f9a0c2e1b7d4a65" {
+		t.Errorf("expected the saved approval, got %v", approvals)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, err := store.LoadTarget(); err != nil || target != nil {
+		t.Fatalf("expected no target before one is saved, got %v, %v", target, err)
+	}
+
+	base := &model.Snapshot{Dataset: "/home", Name: "daily-2024-01-01", CreatedAt: 1}
+	target := model.NewTarget("offsite")
+	target.Current = "111"
+	target.Drives["111"] = &model.TargetDrive{GUID: "111", Name: "offsite", LastSynced: time.Unix(100, 0)}
+	target.Drives["222"] = &model.TargetDrive{GUID: "222", Name: "offsite", Inventory: map[model.DatasetName][]*model.Snapshot{"/home": {base}}}
+	if err := store.SaveTarget(target); err != nil {
+		t.Fatal(err)
+	}
+
+	// As after a restart.
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.LoadTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protected := loaded.Protected("/home"); len(protected) != 1 || protected[0].Name != base.Name {
+		t.Errorf("expected the away drive's base to be protected, got %v", protected)
+	}
+	if !loaded.Drives["111"].LastSynced.Equal(time.Unix(100, 0)) {
+		t.Errorf("unexpected drive %+v", loaded.Drives["111"])
+	}
+}
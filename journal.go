@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"monks.co/backupd/env"
+	"monks.co/backupd/journal"
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+)
+
+// apply applies the operation to zfs, journaling it first so that, if the
+// process dies partway, the next one can tell what it left behind. The
+// dataset's current inventory, if known, says what a range deletion covers.
+// Each attempt is recorded in the metrics.
+func (b *Backupd) apply(ctx context.Context, logger *logger.Logger, op model.Operation, current *model.SnapshotInventory, report func(model.TransferProgress)) (string, error) {
+	entry := journal.NewEntry(op, current)
+	if b.journal != nil {
+		if err := b.journal.Begin(entry); err != nil {
+			return "", fmt.Errorf("journaling op: %w", err)
+		}
+	}
+
+	var bytes atomic.Int64
+	started := time.Now()
+	checksum, err := b.env.Apply(ctx, logger, op, func(progress model.TransferProgress) {
+		bytes.Store(progress.BytesDone)
+		if report != nil {
+			report(progress)
+		}
+	})
+	recordApply(entry.Dataset, entry.Kind, time.Since(started), bytes.Load(), err)
+
+	if b.journal != nil {
+		if jerr := b.journal.End(entry, err); jerr != nil {
+			logger.Printf("-- Error marking op complete in journal: %v", jerr)
+		}
+	}
+	return checksum, err
+}
+
+// reconcileJournal checks the operations a previous process left in flight
+// against zfs, logging what each did, and raising a warning for any that
+// can't be accounted for. Those the remote can't be asked about, because a
+// removable target is away or zfs couldn't be reached, are left for a later
+// cycle.
+func (b *Backupd) reconcileJournal() {
+	if b.journal == nil {
+		return
+	}
+	entries := b.journal.Unfinished()
+	if len(entries) == 0 {
+		return
+	}
+
+	zfs := journalZFS{env: b.env, logger: b.globalLogs}
+	remoteAway := false
+	if target := b.state.Deref().Target; target != nil && !target.Present {
+		remoteAway = true
+	}
+
+	var resolved []*journal.Entry
+	var warnings []string
+	for _, entry := range entries {
+		if remoteAway && entry.Location == model.Remote.String() {
+			continue
+		}
+		outcome := journal.Reconcile(entry, zfs)
+		b.globalLogs.Printf("interrupted operation %s", outcome)
+		if outcome.State == journal.Unchecked {
+			continue
+		}
+		if outcome.Ambiguous() {
+			warnings = append(warnings, "Interrupted operation "+outcome.String())
+		}
+		resolved = append(resolved, entry)
+	}
+
+	if len(warnings) > 0 {
+		b.setWarnings(model.AddWarnings(warnings...))
+	}
+	if err := b.journal.Resolve(resolved); err != nil {
+		b.globalLogs.Printf("resolving journal: %v", err)
+	}
+}
+
+// setWarnings updates the warnings, and saves them so that they're shown
+// until dismissed, even across a restart.
+func (b *Backupd) setWarnings(update func(*model.Model) *model.Model) {
+	b.state.Swap(update)
+	b.notifyStateChange()
+	if b.history == nil {
+		return
+	}
+	if err := b.history.SaveWarnings(b.state.Deref().Warnings); err != nil {
+		b.globalLogs.Printf("%v", err)
+	}
+}
+
+// raiseWarnings adds the given warnings to those shown on the overview,
+// skipping any that are already shown, so that a problem found on every
+// refresh is listed once.
+func (b *Backupd) raiseWarnings(warnings ...string) {
+	b.setWarnings(func(old *model.Model) *model.Model {
+		var fresh []string
+		for _, warning := range warnings {
+			if !slices.Contains(old.Warnings, warning) {
+				fresh = append(fresh, warning)
+			}
+		}
+		if len(fresh) == 0 {
+			return old
+		}
+		return model.AddWarnings(fresh...)(old)
+	})
+}
+
+// loadWarnings restores the warnings saved by a previous process.
+func (b *Backupd) loadWarnings() error {
+	warnings, err := b.history.LoadWarnings()
+	if err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		b.state.Swap(model.AddWarnings(warnings...))
+	}
+	return nil
+}
+
+// journalZFS is what journal entries are reconciled against.
+type journalZFS struct {
+	env    *env.Env
+	logger *logger.Logger
+}
+
+func (zfs journalZFS) at(location string) *env.ZFS {
+	if location == model.Local.String() {
+		return zfs.env.Local
+	}
+	return zfs.env.Remote
+}
+
+func (zfs journalZFS) Snapshots(location string, dataset model.DatasetName) ([]string, bool, error) {
+	snaps, err := zfs.at(location).GetSnapshots(zfs.logger, dataset)
+	if err != nil && strings.Contains(err.Error(), "dataset does not exist") {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	names := make([]string, len(snaps))
+	for i, snap := range snaps {
+		names[i] = snap.Name
+	}
+	return names, true, nil
+}
+
+func (zfs journalZFS) ResumeToken(location string, dataset model.DatasetName) (string, error) {
+	return zfs.at(location).GetResumeToken(zfs.logger, dataset)
+}
@@ -0,0 +1,370 @@
+// Package journal is a write-ahead log of the operations applied to zfs.
+// Each is recorded before it starts and again when it ends, so that after a
+// crash, the operations that were in flight can be checked against what zfs
+// actually holds.
+package journal
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+// An Entry is an operation about to be applied, with what it should leave
+// behind when it's done.
+type Entry struct {
+	ID        int64     `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	Operation string    `json:"operation"`
+	Kind      string    `json:"kind"`
+
+	// Location and Dataset are where the operation's effects show up.
+	Location string            `json:"location"`
+	Dataset  model.DatasetName `json:"dataset"`
+
+	// Removes and Creates are the snapshots the operation destroys or
+	// receives; RenameTo is where a set-aside dataset goes.
+	Removes  []string          `json:"removes,omitempty"`
+	Creates  []string          `json:"creates,omitempty"`
+	RenameTo model.DatasetName `json:"rename_to,omitempty"`
+}
+
+// NewEntry describes what applying op will do. The dataset's current
+// inventory, if known, says which snapshots a range deletion covers.
+func NewEntry(op model.Operation, current *model.SnapshotInventory) *Entry {
+	if step, ok := op.(*model.PlanStep); ok {
+		op = step.Operation
+	}
+	entry := &Entry{
+		StartedAt: time.Now(),
+		Operation: op.String(),
+		Kind:      model.OperationKind(op),
+	}
+	switch op := op.(type) {
+	case *model.SnapshotDeletion:
+		entry.Location, entry.Dataset = op.Location.String(), op.Snapshot.Dataset
+		entry.Removes = []string{op.Snapshot.Name}
+	case *model.SnapshotRangeDeletion:
+		entry.Location, entry.Dataset = op.Location.String(), op.Start.Dataset
+		entry.Removes = []string{op.Start.Name, op.End.Name}
+		if current != nil {
+			if after, err := op.Apply(current); err == nil {
+				entry.Removes = entry.Removes[:0]
+				before, left := current.Local, after.Local
+				if op.Location == model.Remote {
+					before, left = current.Remote, after.Remote
+				}
+				for snap := range before.Difference(left).All() {
+					entry.Removes = append(entry.Removes, snap.Name)
+				}
+			}
+		}
+	case *model.InitialSnapshotTransfer:
+		entry.Location, entry.Dataset = model.Remote.String(), op.Snapshot.Dataset
+		entry.Creates = []string{op.Snapshot.Name}
+	case *model.SnapshotTransfer:
+		entry.Location, entry.Dataset = model.Remote.String(), op.Snapshot.Dataset
+		entry.Creates = []string{op.Snapshot.Name}
+	case *model.SnapshotRangeTransfer:
+		entry.Location, entry.Dataset = model.Remote.String(), op.End.Dataset
+		entry.Creates = []string{op.End.Name}
+	case *model.InitialSnapshotRestore:
+		entry.Location, entry.Dataset = model.Local.String(), op.To
+		entry.Creates = []string{op.Snapshot.Name}
+	case *model.SnapshotRangeRestore:
+		entry.Location, entry.Dataset = model.Local.String(), op.To
+		entry.Creates = []string{op.End.Name}
+	case *model.RemoteDatasetSetAside:
+		entry.Location, entry.Dataset = model.Remote.String(), op.Dataset
+		entry.RenameTo = op.To
+	}
+	return entry
+}
+
+// A record is one line of the journal: an entry beginning, or the end of
+// the entry with the ID.
+type record struct {
+	Begin *Entry `json:"begin,omitempty"`
+	End   int64  `json:"end,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Journal is an append-only file of records, synced to disk as each is
+// written. Whenever nothing is in flight, the file is emptied again.
+type Journal struct {
+	mu       sync.Mutex
+	f        *os.File
+	nextID   int64
+	inFlight map[int64]*Entry
+
+	// unfinished are the entries in flight when the journal was opened.
+	unfinished map[int64]*Entry
+}
+
+// Open opens the journal at path, creating it if need be. Entries that
+// began but never ended, because the process died, are returned by
+// Unfinished until Resolve is called.
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	journal := &Journal{f: f, nextID: 1, inFlight: map[int64]*Entry{}}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A torn final write, from a crash mid-append; what it
+			// would have recorded can't have happened yet.
+			break
+		}
+		switch {
+		case rec.Begin != nil:
+			journal.inFlight[rec.Begin.ID] = rec.Begin
+			journal.nextID = max(journal.nextID, rec.Begin.ID+1)
+		case rec.End != 0:
+			delete(journal.inFlight, rec.End)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+
+	// Rewrite the file with just what's in flight, dropping any torn
+	// write, which would otherwise hide the records after it.
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("compacting journal: %w", err)
+	}
+	journal.unfinished = maps.Clone(journal.inFlight)
+	for _, entry := range journal.Unfinished() {
+		if err := journal.write(record{Begin: entry}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return journal, nil
+}
+
+// Close closes the journal's file.
+func (journal *Journal) Close() error {
+	return journal.f.Close()
+}
+
+// Unfinished returns the entries left in flight by a previous process,
+// oldest first, until they're resolved.
+func (journal *Journal) Unfinished() []*Entry {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	var entries []*Entry
+	for _, entry := range journal.unfinished {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b *Entry) int { return cmp.Compare(a.ID, b.ID) })
+	return entries
+}
+
+// Resolve forgets the given entries, once they've been reconciled.
+func (journal *Journal) Resolve(entries []*Entry) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	for _, entry := range entries {
+		delete(journal.unfinished, entry.ID)
+		delete(journal.inFlight, entry.ID)
+	}
+	return journal.truncateIfIdle()
+}
+
+// Begin records that the entry's operation is about to be applied. It
+// returns once the record is on disk.
+func (journal *Journal) Begin(entry *Entry) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	entry.ID = journal.nextID
+	journal.nextID++
+	if err := journal.write(record{Begin: entry}); err != nil {
+		return err
+	}
+	journal.inFlight[entry.ID] = entry
+	return nil
+}
+
+// End records that the entry's operation finished, successfully or not.
+func (journal *Journal) End(entry *Entry, applyErr error) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	rec := record{End: entry.ID}
+	if applyErr != nil {
+		rec.Error = applyErr.Error()
+	}
+	if err := journal.write(rec); err != nil {
+		return err
+	}
+	delete(journal.inFlight, entry.ID)
+	return journal.truncateIfIdle()
+}
+
+func (journal *Journal) write(rec record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding journal record: %w", err)
+	}
+	if _, err := journal.f.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if _, err := journal.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := journal.f.Sync(); err != nil {
+		return fmt.Errorf("syncing journal: %w", err)
+	}
+	return nil
+}
+
+func (journal *Journal) truncateIfIdle() error {
+	if len(journal.inFlight) > 0 {
+		return nil
+	}
+	if err := journal.f.Truncate(0); err != nil {
+		return fmt.Errorf("truncating journal: %w", err)
+	}
+	return journal.f.Sync()
+}
+
+// State is what reconciliation found of an interrupted operation.
+type State string
+
+const (
+	// Applied operations finished before the crash.
+	Applied State = "applied"
+	// NotApplied operations left no trace; the next plan redoes them if
+	// they're still wanted.
+	NotApplied State = "not applied"
+	// Resumable transfers left a receive resume token.
+	Resumable State = "resumable"
+	// Partial operations did some of what they would have.
+	Partial State = "partial"
+	// Unknown operations left zfs in a state that isn't explained by the
+	// operation having run or not.
+	Unknown State = "unknown"
+	// Unchecked operations couldn't be looked up in zfs, and should be
+	// checked again later.
+	Unchecked State = "unchecked"
+)
+
+// ZFS looks up the state that entries are reconciled against.
+type ZFS interface {
+	// Snapshots lists the dataset's snapshots at the location, or
+	// returns exists = false if there's no such dataset.
+	Snapshots(location string, dataset model.DatasetName) (names []string, exists bool, err error)
+
+	// ResumeToken returns the dataset's receive resume token, if any.
+	ResumeToken(location string, dataset model.DatasetName) (string, error)
+}
+
+// An Outcome is the result of reconciling one entry.
+type Outcome struct {
+	Entry  *Entry
+	State  State
+	Detail string
+}
+
+// Ambiguous reports whether the outcome needs someone to look at it.
+func (outcome Outcome) Ambiguous() bool {
+	return outcome.State == Partial || outcome.State == Unknown
+}
+
+func (outcome Outcome) String() string {
+	s := fmt.Sprintf("'%s', started %s: %s", outcome.Entry.Operation, outcome.Entry.StartedAt.Format(time.DateTime), outcome.State)
+	if outcome.Detail != "" {
+		s += " (" + outcome.Detail + ")"
+	}
+	return s
+}
+
+// Reconcile checks what an interrupted entry's operation did.
+func Reconcile(entry *Entry, zfs ZFS) Outcome {
+	outcome := Outcome{Entry: entry}
+	snaps, exists, err := zfs.Snapshots(entry.Location, entry.Dataset)
+	if err != nil {
+		outcome.State, outcome.Detail = Unchecked, err.Error()
+		return outcome
+	}
+
+	switch {
+	case entry.RenameTo != "":
+		_, renamed, err := zfs.Snapshots(entry.Location, entry.RenameTo)
+		switch {
+		case err != nil:
+			outcome.State, outcome.Detail = Unchecked, err.Error()
+		case renamed && !exists:
+			outcome.State = Applied
+		case exists && !renamed:
+			outcome.State = NotApplied
+		case exists && renamed:
+			outcome.State, outcome.Detail = Unknown, fmt.Sprintf("both %s and %s exist", entry.Dataset, entry.RenameTo)
+		default:
+			outcome.State, outcome.Detail = Unknown, fmt.Sprintf("neither %s nor %s exists", entry.Dataset, entry.RenameTo)
+		}
+
+	case len(entry.Removes) > 0:
+		if !exists {
+			outcome.State, outcome.Detail = Unknown, fmt.Sprintf("%s %s no longer exists", entry.Location, entry.Dataset)
+			return outcome
+		}
+		var left []string
+		for _, name := range entry.Removes {
+			if slices.Contains(snaps, name) {
+				left = append(left, name)
+			}
+		}
+		switch len(left) {
+		case 0:
+			outcome.State = Applied
+		case len(entry.Removes):
+			outcome.State = NotApplied
+		default:
+			outcome.State = Partial
+			outcome.Detail = fmt.Sprintf("%d of %d snapshots remain, from %s", len(left), len(entry.Removes), left[0])
+		}
+
+	case len(entry.Creates) > 0:
+		missing := 0
+		for _, name := range entry.Creates {
+			if !slices.Contains(snaps, name) {
+				missing++
+			}
+		}
+		if missing == 0 {
+			outcome.State = Applied
+			return outcome
+		}
+		if exists {
+			token, err := zfs.ResumeToken(entry.Location, entry.Dataset)
+			if err != nil {
+				outcome.State, outcome.Detail = Unchecked, err.Error()
+				return outcome
+			} else if token != "" {
+				outcome.State = Resumable
+				return outcome
+			}
+		}
+		outcome.State = NotApplied
+
+	default:
+		outcome.State, outcome.Detail = Unknown, "nothing to check"
+	}
+	return outcome
+}
@@ -0,0 +1,147 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"monks.co/backupd/model"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &model.Snapshot{Name: "daily-a", CreatedAt: 1}
+	b := &model.Snapshot{Name: "daily-b", CreatedAt: 2}
+	c := &model.Snapshot{Name: "daily-c", CreatedAt: 3}
+	current := model.NewSnapshotInventory(model.NewSnapshots(a, b, c), model.NewSnapshots(a))
+
+	done := NewEntry(&model.SnapshotRangeTransfer{Start: a, End: c}, current)
+	if err := journal.Begin(done); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.End(done, nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Size() != 0 {
+		t.Errorf("expected the journal to be emptied once idle, got %d bytes", info.Size())
+	}
+
+	failed := NewEntry(&model.SnapshotDeletion{Location: model.Remote, Snapshot: a}, current)
+	if err := journal.Begin(failed); err != nil {
+		t.Fatal(err)
+	}
+	crashed := NewEntry(&model.SnapshotRangeDeletion{Location: model.Local, Start: a, End: c}, current)
+	if err := journal.Begin(crashed); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.End(failed, errors.New("dataset is busy")); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	// Simulate a crash mid-append.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"begin":{"id":9`)
+	f.Close()
+
+	journal, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfinished := journal.Unfinished()
+	if len(unfinished) != 1 || unfinished[0].Operation != crashed.Operation {
+		t.Fatalf("expected only the range deletion to be unfinished, got %+v", unfinished)
+	}
+	if got := unfinished[0].Removes; len(got) != 3 || got[0] != "daily-a" || got[2] != "daily-c" {
+		t.Errorf("expected the range deletion to remove every snapshot in the range, got %v", got)
+	}
+
+	next := NewEntry(&model.SnapshotDeletion{Location: model.Local, Snapshot: b}, current)
+	if err := journal.Begin(next); err != nil {
+		t.Fatal(err)
+	}
+	if next.ID <= crashed.ID {
+		t.Errorf("expected new IDs after %d, got %d", crashed.ID, next.ID)
+	}
+	if got := journal.Unfinished(); len(got) != 1 {
+		t.Errorf("expected only the previous process's entries to be unfinished, got %+v", got)
+	}
+	if err := journal.End(next, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Resolve(unfinished); err != nil {
+		t.Fatal(err)
+	}
+	if got := journal.Unfinished(); len(got) != 0 {
+		t.Errorf("expected nothing unfinished once resolved, got %+v", got)
+	}
+}
+
+type fakeZFS map[string][]string
+
+func (zfs fakeZFS) Snapshots(location string, dataset model.DatasetName) ([]string, bool, error) {
+	snaps, ok := zfs[location+":"+string(dataset)]
+	return snaps, ok, nil
+}
+
+func (zfs fakeZFS) ResumeToken(location string, dataset model.DatasetName) (string, error) {
+	if snaps := zfs[location+":"+string(dataset)]; len(snaps) > 0 && snaps[len(snaps)-1] == "token" {
+		return "1-abc", nil
+	}
+	return "", nil
+}
+
+// unreachableZFS fails every lookup, as when ssh to the remote is down.
+type unreachableZFS struct{}
+
+func (unreachableZFS) Snapshots(string, model.DatasetName) ([]string, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (unreachableZFS) ResumeToken(string, model.DatasetName) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestReconcile(t *testing.T) {
+	deletion := &Entry{Location: "Local", Dataset: "/home", Removes: []string{"a", "b", "c"}}
+	transfer := &Entry{Location: "Remote", Dataset: "/home", Creates: []string{"c"}}
+	setAside := &Entry{Location: "Remote", Dataset: "/home", RenameTo: "/home.orphaned"}
+
+	for _, tt := range []struct {
+		name  string
+		entry *Entry
+		zfs   ZFS
+		state State
+	}{
+		{"deleted", deletion, fakeZFS{"Local:/home": {"d"}}, Applied},
+		{"not deleted", deletion, fakeZFS{"Local:/home": {"a", "b", "c", "d"}}, NotApplied},
+		{"partly deleted", deletion, fakeZFS{"Local:/home": {"c", "d"}}, Partial},
+		{"dataset gone", deletion, fakeZFS{}, Unknown},
+		{"transferred", transfer, fakeZFS{"Remote:/home": {"a", "c"}}, Applied},
+		{"not transferred", transfer, fakeZFS{"Remote:/home": {"a"}}, NotApplied},
+		{"initial not transferred", transfer, fakeZFS{}, NotApplied},
+		{"resumable", transfer, fakeZFS{"Remote:/home": {"a", "token"}}, Resumable},
+		{"set aside", setAside, fakeZFS{"Remote:/home.orphaned": {"a"}}, Applied},
+		{"not set aside", setAside, fakeZFS{"Remote:/home": {"a"}}, NotApplied},
+		{"both", setAside, fakeZFS{"Remote:/home": {}, "Remote:/home.orphaned": {"a"}}, Unknown},
+		{"unreachable", transfer, unreachableZFS{}, Unchecked},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome := Reconcile(tt.entry, tt.zfs)
+			if outcome.State != tt.state {
+				t.Errorf("got %s, expected %s", outcome, tt.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"monks.co/backupd/config"
+	"monks.co/backupd/model"
+)
+
+// holdExcessiveDeletions holds the plan's deletions for approval if there
+// are more than the config allows. The held deletions are deferred until
+// the plan's transfers have run, with targetFor recomputing the target from
+// the inventory they leave, so that an approval still applies afterwards.
+func holdExcessiveDeletions(conf *config.Config, plan *model.Plan, current, target *model.SnapshotInventory, targetFor func(*model.SnapshotInventory) *model.SnapshotInventory) (*model.SnapshotInventory, *model.Plan, error) {
+	localLimit, remoteLimit := localDeletionLimitFor(conf), remoteDeletionLimitFor(conf)
+	model.HoldExcessiveDeletions(plan, current, target, localLimit, remoteLimit)
+	return model.DeferHeldDeletions(plan, current, target, targetFor, localLimit, remoteLimit)
+}
+
+// localDeletionLimitFor returns how many local snapshots a plan may delete
+// without approval.
+func localDeletionLimitFor(conf *config.Config) model.DeletionLimit {
+	return model.DeletionLimit{
+		Max:      conf.Local.MaxDeletions,
+		MaxShare: conf.Local.MaxDeletionShare,
+	}
+}
+
+// remoteDeletionLimitFor returns how many remote snapshots a plan may delete
+// without approval.
+func remoteDeletionLimitFor(conf *config.Config) model.DeletionLimit {
+	return model.DeletionLimit{
+		Max:      conf.Remote.MaxDeletions,
+		MaxShare: conf.Remote.MaxDeletionShare,
+	}
+}
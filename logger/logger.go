@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,12 +14,25 @@ type Logger struct {
 }
 
 type LogEntry struct {
-	LogAt time.Time
-	Log   string
+	LogAt time.Time `json:"at"`
+	Log   string    `json:"log"`
 }
 
 var _ io.Writer = &Logger{}
 
+var observer atomic.Pointer[func(*Logger, LogEntry)]
+
+// Observe calls fn with every line written to any logger, or stops calling
+// it if fn is nil. It's called on the writing goroutine, so it mustn't
+// block.
+func Observe(fn func(*Logger, LogEntry)) {
+	if fn == nil {
+		observer.Store(nil)
+		return
+	}
+	observer.Store(&fn)
+}
+
 func New(label string) *Logger {
 	return &Logger{
 		label: label,
@@ -37,6 +51,9 @@ func (p *Logger) Write(bs []byte) (int, error) {
 	}
 	p.logs = append(p.logs, entry)
 	log.Println(fmt.Sprintf("[%s]\t", p.label) + string(bs))
+	if fn := observer.Load(); fn != nil {
+		(*fn)(p, entry)
+	}
 	return len(bs), nil
 }
 
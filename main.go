@@ -6,12 +6,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"os/user"
+	"strings"
 
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 
 	"monks.co/backupd/config"
-	"monks.co/backupd/model"
+	"monks.co/backupd/env"
 )
 
 func main() {
@@ -45,11 +47,37 @@ func run() error {
 		fmt.Println("USAGE:")
 		fmt.Println("    backupd [OPTIONS]                    # Start backup daemon")
 		fmt.Println("    backupd snapshot <periodicity>      # Create snapshot and update state")
+		fmt.Println("    backupd restore <dataset> [snapshot] [--to <dataset>]")
+		fmt.Println("                                         # Pull a snapshot back from the remote")
+		fmt.Println("    backupd approve <dataset> [plan]    # Show a held plan, or approve it by fingerprint")
+		fmt.Println("    backupd policy diff --config <path> # Preview what a proposed config would change")
+		fmt.Println("    backupd simulate [-months <n>] [-schedule <type>=<cron>]... [-html <file>]")
+		fmt.Println("                                         # Simulate the retention policies over time")
+		fmt.Println("    backupd receive <zfs receive args>  # Checksumming zfs receive (run on the remote)")
+		fmt.Println("    backupd receive-server -root <remote root> <TLS options> [-psk-file <file>]")
+		fmt.Println("                                         # Receive streams over TCP (run on the remote)")
+		fmt.Println("    backupd ssh-guard -root <root>")
+		fmt.Println("                                         # Restrict an authorized_keys entry (run on the remote)")
+		fmt.Println("    backupd seed export [-chunk-size <size>] <dataset> <dir> [snapshot]")
+		fmt.Println("                                         # Write a snapshot to files for offline seeding")
+		fmt.Println("    backupd seed import <dir> <remote root>")
+		fmt.Println("                                         # Receive exported files (run on the remote)")
+		fmt.Println("    backupd seed cancel <dataset>       # Send over the network instead of waiting for a seed")
 		fmt.Println()
 		fmt.Println("EXAMPLES:")
 		fmt.Println("    backupd snapshot daily     # Create daily snapshot")
 		fmt.Println("    backupd snapshot monthly   # Create monthly snapshot")
 		fmt.Println("    backupd snapshot yearly    # Create yearly snapshot")
+		fmt.Println("    backupd restore /home      # Restore newest remote snapshot of /home")
+		fmt.Println("    backupd restore /home daily-2024-01-01-00:00:00 --to /home-restored")
+		fmt.Println("    backupd approve /home      # Review the plan held for /home")
+		fmt.Println("    backupd approve /home 3f9a0c2e1b7d4a65")
+		fmt.Println("    backupd policy diff --config /etc/backupd.new.toml")
+		fmt.Println("    backupd simulate -config /etc/backupd.new.toml -months 24 -html timeline.html")
+		fmt.Println("    backupd receive-server -root tank/backups -cert server.pem -key server.key -client-ca ca.pem")
+		fmt.Println("    backupd ssh-guard -root tank/backups -helper /usr/local/bin/backupd")
+		fmt.Println("    backupd seed export /home /mnt/usb/home")
+		fmt.Println("    backupd seed import /mnt/usb/home tank/backups")
 		fmt.Println()
 		fmt.Println("OPTIONS:")
 		flag.PrintDefaults()
@@ -64,6 +92,73 @@ func run() error {
 			if len(args) != 2 {
 				return fmt.Errorf("usage: backupd snapshot <periodicity>")
 			}
+		case "restore":
+			restoreArgs, err := parseRestoreArgs(args[1:])
+			if err != nil {
+				return err
+			}
+			args = append([]string{"restore"}, restoreArgs...)
+		case "approve":
+			if len(args) != 2 && len(args) != 3 {
+				return fmt.Errorf("usage: backupd approve <dataset> [plan]")
+			}
+			if len(args) == 2 {
+				args = append(args, "")
+			}
+		case "policy":
+			path, err := parsePolicyArgs(args[1:])
+			if err != nil {
+				return err
+			}
+			args = []string{"policy", path}
+		case "simulate":
+			// Simulation is pure computation over a config file, so
+			// anyone may run it.
+			return simulateRetention(args[1:])
+		case "receive":
+			// The receive helper is run by the sending backupd over
+			// ssh; it needs neither root nor a config file.
+			if len(args) < 2 {
+				return fmt.Errorf("usage: backupd receive <zfs receive args>")
+			}
+			return env.Receive(NewSigctx(), os.Stdin, os.Stdout, os.Stderr, args[1:]...)
+		case "receive-server":
+			return receiveServer(NewSigctx(), args[1:])
+		case "receive-client":
+			// Run by the daemon; see receiveClient.
+			if len(args) < 2 {
+				return fmt.Errorf("usage: backupd receive-client <zfs receive args>")
+			}
+		case "ssh-guard":
+			// The guard runs on the remote as a forced command, as
+			// whichever user the key logs in as.
+			return sshGuard(args[1:])
+		case "seed":
+			const usage = "usage: backupd seed export [-chunk-size <size>] <dataset> <dir> [snapshot]\n       backupd seed import <dir> <remote root>\n       backupd seed cancel <dataset>"
+			if len(args) < 2 {
+				return fmt.Errorf("%s", usage)
+			}
+			switch args[1] {
+			case "export":
+				exportArgs, err := parseSeedExportArgs(args[2:])
+				if err != nil {
+					return err
+				}
+				args = append([]string{"seed", "export"}, exportArgs...)
+			case "import":
+				// Importing happens on the remote, which has no
+				// backupd config of its own.
+				if len(args) != 4 {
+					return fmt.Errorf("%s", usage)
+				}
+				return importSeed(NewSigctx(), args[2], args[3])
+			case "cancel":
+				if len(args) != 3 {
+					return fmt.Errorf("%s", usage)
+				}
+			default:
+				return fmt.Errorf("%s", usage)
+			}
 		default:
 			return fmt.Errorf("unknown command: %s\nRun 'backupd --help' for usage information", args[0])
 		}
@@ -82,6 +177,10 @@ func run() error {
 	}
 
 	ctx := NewSigctx()
+	if len(args) > 0 && args[0] == "receive-client" {
+		return receiveClient(ctx, config, args[1:])
+	}
+
 	b := New(config, addr, dryrun)
 
 	// Execute subcommands
@@ -89,15 +188,23 @@ func run() error {
 		switch args[0] {
 		case "snapshot":
 			return b.CreateSnapshot(ctx, args[1])
+		case "restore":
+			return b.RequestRestore(ctx, args[1], args[2], args[3])
+		case "approve":
+			return b.RequestApproval(ctx, args[1], args[2])
+		case "policy":
+			return b.RequestPolicyDiff(ctx, args[1])
+		case "seed":
+			if args[1] == "cancel" {
+				return b.CancelSeed(ctx, args[2])
+			}
+			return b.ExportSeed(ctx, args[2], args[3], args[4], args[5])
 		}
 	}
 
 	if debugDS != "" {
-		if debugDS == "<root>" {
-			debugDS = ""
-		}
 		logger := b.globalLogs
-		ds := model.DatasetName(debugDS)
+		ds := parseDatasetName(debugDS)
 		if err := b.refreshDataset(ctx, logger, ds); err != nil {
 			return err
 		} else if err := b.Plan(ctx, ds); err != nil {
@@ -123,3 +230,36 @@ func run() error {
 
 	return nil
 }
+
+// parseRestoreArgs parses `<dataset> [snapshot] [--to <dataset>]` into
+// dataset, snapshot, and destination, with "" for omitted values.
+func parseRestoreArgs(args []string) ([]string, error) {
+	const usage = "usage: backupd restore <dataset> [snapshot] [--to <dataset>]"
+
+	var positional []string
+	var to string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--to" || arg == "-to":
+			if i+1 >= len(args) {
+				return nil, errors.New(usage)
+			}
+			i++
+			to = args[i]
+		case strings.HasPrefix(arg, "--to="):
+			to = strings.TrimPrefix(arg, "--to=")
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown flag %s\n%s", arg, usage)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return nil, errors.New(usage)
+	}
+	if len(positional) == 1 {
+		positional = append(positional, "")
+	}
+
+	return append(positional, to), nil
+}
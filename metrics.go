@@ -0,0 +1,156 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"monks.co/backupd/history"
+	"monks.co/backupd/model"
+)
+
+var (
+	transferredBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backupd_transferred_bytes_total",
+		Help: "Bytes sent by transfers and restores, by dataset and operation kind.",
+	}, []string{"dataset", "kind"})
+	stepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "backupd_step_duration_seconds",
+		Help:    "Time taken to apply plan steps to zfs, by operation kind.",
+		Buckets: []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+	}, []string{"kind"})
+	stepFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backupd_step_failures_total",
+		Help: "Attempts to apply plan steps to zfs that failed, by operation kind.",
+	}, []string{"kind"})
+)
+
+// recordApply records an attempt to apply an operation in the metrics.
+func recordApply(dataset model.DatasetName, kind string, took time.Duration, bytes int64, err error) {
+	stepDuration.WithLabelValues(kind).Observe(took.Seconds())
+	if bytes > 0 {
+		transferredBytes.WithLabelValues(dataset.String(), kind).Add(float64(bytes))
+	}
+	if err != nil {
+		stepFailures.WithLabelValues(kind).Inc()
+	}
+}
+
+// setLastSynced records that the dataset finished a sync cycle without
+// error.
+func (b *Backupd) setLastSynced(dataset model.DatasetName, at time.Time) {
+	b.lastSynced.Swap(func(old map[model.DatasetName]time.Time) map[model.DatasetName]time.Time {
+		out := maps.Clone(old)
+		out[dataset] = at
+		return out
+	})
+}
+
+// loadLastSynced fills in when each dataset last synced from the recorded
+// history, so that it's known before the first cycle finishes.
+func (b *Backupd) loadLastSynced() error {
+	cycles, err := b.history.List("", historyLimit)
+	if err != nil {
+		return err
+	}
+	last := map[model.DatasetName]time.Time{}
+	for _, cycle := range cycles {
+		if cycle.FinishedAt == nil {
+			continue
+		}
+		for _, run := range cycle.Datasets {
+			name := parseDatasetName(run.Dataset)
+			if _, ok := last[name]; !ok && run.Status == history.StatusOK {
+				last[name] = *cycle.FinishedAt
+			}
+		}
+	}
+	b.lastSynced.Reset(last)
+	return nil
+}
+
+var (
+	snapshotsDesc = prometheus.NewDesc("backupd_snapshots",
+		"Snapshots of the dataset at each location.", []string{"dataset", "location"}, nil)
+	usedDesc = prometheus.NewDesc("backupd_used_bytes",
+		"Space used by the dataset and its snapshots at each location.", []string{"dataset", "location"}, nil)
+	logicalDesc = prometheus.NewDesc("backupd_logical_referenced_bytes",
+		"Logical size of the dataset at each location.", []string{"dataset", "location"}, nil)
+	stalenessDesc = prometheus.NewDesc("backupd_staleness_seconds",
+		"How far the remote's newest snapshot is behind the local one.", []string{"dataset"}, nil)
+	newestDesc = prometheus.NewDesc("backupd_newest_snapshot_timestamp_seconds",
+		"Creation time of the newest snapshot of each type at each location.", []string{"dataset", "location", "type"}, nil)
+	lastSyncedDesc = prometheus.NewDesc("backupd_last_sync_success_timestamp_seconds",
+		"When the dataset last finished a sync cycle without error.", []string{"dataset"}, nil)
+)
+
+// datasetCollector collects the dataset gauges from the current state at
+// each scrape.
+type datasetCollector struct {
+	b *Backupd
+}
+
+func (c datasetCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{snapshotsDesc, usedDesc, logicalDesc, stalenessDesc, newestDesc, lastSyncedDesc} {
+		ch <- desc
+	}
+}
+
+func (c datasetCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	if state := c.b.state.Deref(); state != nil {
+		for _, name := range state.ListDatasets() {
+			ds := state.GetDataset(name)
+			dataset := name.String()
+			if ds.Current != nil {
+				for _, side := range []struct {
+					location string
+					snaps    *model.Snapshots
+				}{{"local", ds.Current.Local}, {"remote", ds.Current.Remote}} {
+					gauge(snapshotsDesc, float64(side.snaps.Len()), dataset, side.location)
+					byType := map[string]int64{}
+					for snap := range side.snaps.All() {
+						byType[snap.Type()] = max(byType[snap.Type()], snap.CreatedAt)
+					}
+					for typ, at := range byType {
+						gauge(newestDesc, float64(at), dataset, side.location, typ)
+					}
+				}
+			}
+			if ds.Metrics.HasLocal {
+				gauge(usedDesc, float64(ds.Metrics.LocalSize.Used), dataset, "local")
+				gauge(logicalDesc, float64(ds.Metrics.LocalSize.LogicalReferenced), dataset, "local")
+			}
+			if ds.Metrics.HasRemote {
+				gauge(usedDesc, float64(ds.Metrics.RemoteSize.Used), dataset, "remote")
+				gauge(logicalDesc, float64(ds.Metrics.RemoteSize.LogicalReferenced), dataset, "remote")
+			}
+			gauge(stalenessDesc, ds.Staleness().Seconds(), dataset)
+		}
+	}
+	for name, at := range c.b.lastSynced.Deref() {
+		gauge(lastSyncedDesc, float64(at.Unix()), name.String())
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text format: those
+// registered with the default registry, and the dataset gauges.
+func (b *Backupd) metricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(datasetCollector{b})
+	metrics := promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, registry}, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		metrics.ServeHTTP(w, req)
+	})
+}
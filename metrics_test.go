@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	b := testAPI(t)
+	b.setLastSynced("/home", time.Unix(1700000000, 0))
+	recordApply("/home", "range_transfer", time.Second, 1024, errors.New("failed"))
+
+	w := httptest.NewRecorder()
+	b.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	for _, want := range []string{
+		`backupd_snapshots{dataset="/home",location="local"} 2`,
+		`backupd_snapshots{dataset="/home",location="remote"} 1`,
+		`backupd_last_sync_success_timestamp_seconds{dataset="/home"} 1.7e+09`,
+		`backupd_transferred_bytes_total{dataset="/home",kind="range_transfer"} 1024`,
+		`backupd_step_failures_total{kind="range_transfer"} 1`,
+		`backupd_step_duration_seconds_count{kind="range_transfer"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, w.Body.String())
+		}
+	}
+}
@@ -54,6 +54,28 @@ func (dataset *Dataset) Staleness() time.Duration {
 	return local.Time().Sub(remote.Time())
 }
 
+// ActiveTransfer returns the progress of the transfer currently being
+// executed for this dataset, or nil if none is running.
+func (dataset *Dataset) ActiveTransfer() *TransferProgress {
+	if step := dataset.Plan.ActiveStep(); step != nil {
+		return step.Progress
+	}
+	return nil
+}
+
+// Retention explains what the dataset's goal does with the snapshot at the
+// given location. It returns false if there's no goal, or if the snapshot
+// neither is nor will be at that location.
+func (dataset *Dataset) Retention(location Location, snap *Snapshot) (Reason, bool) {
+	if dataset.Target == nil {
+		return Reason{}, false
+	}
+	if !dataset.Current.at(location).Has(snap) && !dataset.Target.at(location).Has(snap) {
+		return Reason{}, false
+	}
+	return dataset.Target.Reason(location, snap), true
+}
+
 func (dataset *Dataset) String() string {
 	if dataset.Current == nil {
 		return fmt.Sprintf("<%s: uninitialized>", dataset.Name)
@@ -126,29 +148,12 @@ func (dataset *Dataset) Eq(other *Dataset) bool {
 }
 
 func (dataset *Dataset) Clone() *Dataset {
-	// Copy plan (plan steps need to be cloned)
-	var plan *Plan
-	if dataset.Plan != nil {
-		steps := make([]*PlanStep, len(dataset.Plan.Steps))
-		for i, step := range dataset.Plan.Steps {
-			steps[i] = &PlanStep{
-				Operation: step.Operation,
-				Status:    step.Status,
-				StartedAt: step.StartedAt,
-				StoppedAt: step.StoppedAt,
-				Logs:      step.Logs, // ProcessLogs is a pointer, share the same logs
-			}
-		}
-		plan = &Plan{
-			Steps: steps,
-		}
-	}
 	return &Dataset{
 		Name:    dataset.Name,
 		Current: dataset.Current.Clone(),
 		Target:  dataset.Target.Clone(),
 		Metrics: dataset.Metrics, // Value type, no need to clone
-		Plan:    plan,
+		Plan:    dataset.Plan.Clone(),
 		Logs:    dataset.Logs,
 	}
 }
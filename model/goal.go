@@ -1,73 +1,199 @@
 package model
 
-import "log"
+import (
+	"log"
+	"time"
+)
 
-func CalculateTargetInventory(current *SnapshotInventory, localPolicy, remotePolicy map[string]int) *SnapshotInventory {
+// CalculateTargetInventory returns the goal inventory for a dataset. The
+// goal records why it keeps or drops each snapshot; see Reason.
+func CalculateTargetInventory(current *SnapshotInventory, localPolicy, remotePolicy Policy, now time.Time) *SnapshotInventory {
 	localSnapshots := current.Local
 	remoteSnapshots := current.Remote
 
-	sharedSnapshots := localSnapshots.Intersection(remoteSnapshots)
 	allSnapshots := localSnapshots.Union(remoteSnapshots)
 
-	goal := &SnapshotInventory{
-		Local:  NewSnapshots(),
-		Remote: NewSnapshots(),
-	}
+	goal := NewSnapshotInventory(NewSnapshots(), NewSnapshots())
+	keepPinned(current, goal)
 
 	// Keep all snapshots matching the policy
-	localMatches := allSnapshots.MatchingPolicy(localPolicy)
-	for snap := range localMatches.All() {
-		// too bad; already lost :shrug:
-		if !localSnapshots.Has(snap) {
-			continue
-		}
+	localMatches, localWhy := allSnapshots.ExplainPolicy(localPolicy)
+	for snap := range localSnapshots.All() {
+		switch {
+		case !localMatches.Has(snap):
+			goal.Drop(Local, snap, localWhy[snap.ID()])
+
+		// too old, whatever the count
+		case localPolicy.Expired(snap, now):
+			goal.Drop(Local, snap, localPolicy.expiredWhy(snap, now))
 
 		// keep it
-		goal.Local.Add(snap)
+		default:
+			goal.Keep(Local, snap, localWhy[snap.ID()])
+		}
 	}
-	remoteMatches := allSnapshots.MatchingPolicy(remotePolicy)
-	for snap := range remoteMatches.All() {
+	remoteMatches, remoteWhy := allSnapshots.ExplainPolicy(remotePolicy)
+	for snap := range allSnapshots.All() {
+		why := remoteWhy[snap.ID()]
+		switch {
+		case !remoteMatches.Has(snap):
+			goal.Drop(Remote, snap, why)
+
+		// too old, whatever the count
+		case remotePolicy.Expired(snap, now):
+			goal.Drop(Remote, snap, remotePolicy.expiredWhy(snap, now))
+
 		// keep it
-		if remoteSnapshots.Has(snap) {
-			goal.Remote.Add(snap)
-			continue
-		}
+		case remoteSnapshots.Has(snap):
+			goal.Keep(Remote, snap, why)
 
 		// too bad; already lost :shrug:
-		if !localSnapshots.Has(snap) {
+		case !localSnapshots.Has(snap):
+
+		// too bad; already skipped it :shrug:
+		case remoteSnapshots.Newest() != nil && snap.CreatedAt < remoteSnapshots.Newest().CreatedAt:
+			goal.Drop(Remote, snap, "older than the remote's newest, so it can't be sent ("+why+")")
+
+		// transfer it
+		default:
+			log.Printf("keep %s", snap.ID())
+			goal.Keep(Local, snap, "until sent to remote ("+why+")")
+			goal.Keep(Remote, snap, why)
+		}
+	}
+
+	keepYoung(current, goal, localPolicy, remotePolicy, now)
+	keepAnchors(current, goal, localPolicy, remotePolicy, now)
+
+	return goal
+}
+
+// CalculateArchiveTargetInventory is CalculateTargetInventory for a remote
+// in archive mode. Every local snapshot newer than the remote's newest is
+// transferred, whatever its type, so that nothing is deleted locally before
+// it has been archived; and the remote policy thins the remote's own
+// snapshots, independently of what local still has.
+func CalculateArchiveTargetInventory(current *SnapshotInventory, localPolicy, remotePolicy Policy, now time.Time) *SnapshotInventory {
+	localSnapshots := current.Local
+	remoteSnapshots := current.Remote
+
+	goal := NewSnapshotInventory(NewSnapshots(), NewSnapshots())
+	keepPinned(current, goal)
+
+	localMatches, localWhy := localSnapshots.Union(remoteSnapshots).ExplainPolicy(localPolicy)
+	for snap := range localSnapshots.All() {
+		switch {
+		case !localMatches.Has(snap):
+			goal.Drop(Local, snap, localWhy[snap.ID()])
+		case localPolicy.Expired(snap, now):
+			goal.Drop(Local, snap, localPolicy.expiredWhy(snap, now))
+		default:
+			goal.Keep(Local, snap, localWhy[snap.ID()])
+		}
+	}
+
+	remoteMatches, remoteWhy := remoteSnapshots.ExplainPolicy(remotePolicy)
+	for snap := range remoteSnapshots.All() {
+		switch {
+		case !remoteMatches.Has(snap):
+			goal.Drop(Remote, snap, remoteWhy[snap.ID()])
+		case remotePolicy.Expired(snap, now):
+			goal.Drop(Remote, snap, remotePolicy.expiredWhy(snap, now))
+		default:
+			goal.Keep(Remote, snap, remoteWhy[snap.ID()])
+		}
+	}
+
+	// Transfer everything the remote doesn't have yet. Snapshots older
+	// than the remote's newest can't be sent incrementally anymore.
+	newest := remoteSnapshots.Newest()
+	for snap := range localSnapshots.All() {
+		if remoteSnapshots.Has(snap) {
 			continue
 		}
+		switch {
+		case remotePolicy.Expired(snap, now):
+			goal.Drop(Remote, snap, remotePolicy.expiredWhy(snap, now))
+		case newest == nil || snap.CreatedAt > newest.CreatedAt:
+			goal.Keep(Local, snap, "until archived to remote")
+			goal.Keep(Remote, snap, "archived")
+		default:
+			goal.Drop(Remote, snap, "older than the remote's newest, so it can't be sent")
+		}
+	}
 
-		// too bad; already skipped it :shrug:
-		if newest := remoteSnapshots.Newest(); newest != nil && snap.CreatedAt < newest.CreatedAt {
+	keepYoung(current, goal, localPolicy, remotePolicy, now)
+	keepAnchors(current, goal, localPolicy, remotePolicy, now)
+
+	return goal
+}
+
+// keepPinned keeps pinned snapshots wherever they are, whatever the
+// policies and ages, and sends them to the remote while that's still
+// possible. It comes first, so that pinned snapshots are explained as such.
+func keepPinned(current, goal *SnapshotInventory) {
+	newest := current.Remote.Newest()
+	for snap := range current.Local.Union(current.Remote).All() {
+		if !current.Pinned(snap) {
 			continue
 		}
+		if current.Local.Has(snap) {
+			goal.Keep(Local, snap, "pinned")
+		}
+		if current.Remote.Has(snap) || newest == nil || snap.CreatedAt > newest.CreatedAt {
+			goal.Keep(Remote, snap, "pinned")
+		}
+	}
+}
 
-		// transfer it
-		log.Printf("keep %s", snap.ID())
-		goal.Local.Add(snap)
-		goal.Remote.Add(snap)
+// keepYoung keeps every snapshot younger than its location's MinAge, so
+// that a burst of new snapshots can't push out ones that were only just
+// taken.
+func keepYoung(current, goal *SnapshotInventory, localPolicy, remotePolicy Policy, now time.Time) {
+	for snap := range current.Local.All() {
+		if localPolicy.Young(snap, now) {
+			goal.Keep(Local, snap, localPolicy.youngWhy(snap, now))
+		}
 	}
+	for snap := range current.Remote.All() {
+		if remotePolicy.Young(snap, now) {
+			goal.Keep(Remote, snap, remotePolicy.youngWhy(snap, now))
+		}
+	}
+}
+
+// keepAnchors adds the snapshots every goal must keep: the oldest on each
+// side, and the earliest and latest shared, which incremental transfers
+// are based on. MaxAge overrides all but the latest shared snapshot, which
+// the next incremental transfer needs.
+func keepAnchors(current, goal *SnapshotInventory, localPolicy, remotePolicy Policy, now time.Time) {
+	sharedSnapshots := current.Local.Intersection(current.Remote)
 
 	// Keep the oldest snapshot we have
-	if snap := localSnapshots.Oldest(); snap != nil {
-		goal.Local.Add(snap)
+	if snap := current.Local.Oldest(); snap != nil {
+		keepUnlessExpired(goal, Local, snap, localPolicy, now, "oldest local")
 	}
-	if snap := remoteSnapshots.Oldest(); snap != nil {
-		goal.Remote.Add(snap)
+	if snap := current.Remote.Oldest(); snap != nil {
+		keepUnlessExpired(goal, Remote, snap, remotePolicy, now, "oldest remote")
 	}
 
 	// Keep the earliest shared snapshot
 	if snap := sharedSnapshots.Oldest(); snap != nil {
-		goal.Local.Add(snap)
-		goal.Remote.Add(snap)
+		keepUnlessExpired(goal, Local, snap, localPolicy, now, "earliest shared")
+		keepUnlessExpired(goal, Remote, snap, remotePolicy, now, "earliest shared")
 	}
 
 	// Keep the latest shared snapshot
 	if snap := sharedSnapshots.Newest(); snap != nil {
-		goal.Local.Add(snap)
-		goal.Remote.Add(snap)
+		goal.Keep(Local, snap, "newest shared")
+		goal.Keep(Remote, snap, "newest shared")
 	}
+}
 
-	return goal
+func keepUnlessExpired(goal *SnapshotInventory, location Location, snap *Snapshot, policy Policy, now time.Time, why string) {
+	if policy.Expired(snap, now) {
+		goal.Drop(location, snap, why+", but "+policy.expiredWhy(snap, now))
+		return
+	}
+	goal.Keep(location, snap, why)
 }
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyKeepingAll(t *testing.T) {
+	local := NewSnapshots(&Snapshot{Name: "hourly-1", CreatedAt: 1}, &Snapshot{Name: "hourly-2", CreatedAt: 2}, &Snapshot{Name: "daily-3", CreatedAt: 3}, &Snapshot{Name: "hourly-4", CreatedAt: 4})
+	remote := NewSnapshots(&Snapshot{Name: "daily-3", CreatedAt: 3})
+	current := NewSnapshotInventory(local, remote)
+
+	policy := PolicyKeepingAll(current.Local.Union(current.Remote))
+	target := CalculateTargetInventory(current, policy, Policy{Counts: map[string]int{"daily": 1, "hourly": 1}}, time.Now())
+	plan, err := CalculateTransitionPlan(current, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range plan.Steps {
+		switch op := step.Operation.(type) {
+		case *SnapshotDeletion:
+			if op.Location == Local {
+				t.Errorf("unexpected local deletion: %s", op)
+			}
+		case *SnapshotRangeDeletion:
+			if op.Location == Local {
+				t.Errorf("unexpected local deletion: %s", op)
+			}
+		}
+	}
+	if !target.Remote.Has(&Snapshot{Name: "hourly-4", CreatedAt: 4}) {
+		t.Errorf("expected the newest hourly to be sent onward")
+	}
+}
+
+func TestCalculateArchiveTargetInventory(t *testing.T) {
+	local := NewSnapshots(&Snapshot{Name: "hourly-2", CreatedAt: 2}, &Snapshot{Name: "hourly-3", CreatedAt: 3}, &Snapshot{Name: "hourly-4", CreatedAt: 4}, &Snapshot{Name: "hourly-5", CreatedAt: 5})
+	remote := NewSnapshots(&Snapshot{Name: "daily-0", CreatedAt: 0}, &Snapshot{Name: "hourly-1", CreatedAt: 1}, &Snapshot{Name: "hourly-2", CreatedAt: 2})
+	current := NewSnapshotInventory(local, remote)
+
+	target := CalculateArchiveTargetInventory(current, Policy{Counts: map[string]int{"hourly": 1}}, Policy{Counts: map[string]int{"daily": 10}}, time.Now())
+
+	// Everything newer than the remote's newest is transferred, even
+	// though the remote policy keeps no hourlies.
+	for _, name := range []string{"hourly-3", "hourly-4", "hourly-5"} {
+		if !target.Remote.Has(&Snapshot{Name: name, CreatedAt: 0}) || !target.Local.Has(&Snapshot{Name: name, CreatedAt: 0}) {
+			t.Errorf("expected %s to be kept until archived", name)
+		}
+	}
+
+	// The remote thins its own snapshots, but keeps its base for
+	// incremental transfers.
+	if !target.Remote.Has(&Snapshot{Name: "daily-0", CreatedAt: 0}) {
+		t.Errorf("expected daily-0 to be kept by the remote policy")
+	}
+	if target.Remote.Has(&Snapshot{Name: "hourly-1", CreatedAt: 1}) {
+		t.Errorf("expected hourly-1 to be thinned from the remote")
+	}
+	if !target.Remote.Has(&Snapshot{Name: "hourly-2", CreatedAt: 2}) {
+		t.Errorf("expected the latest shared snapshot to be kept")
+	}
+}
+
+func TestCalculateTargetInventoryAges(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) int64 { return now.Add(-d).Unix() }
+
+	// A burst of manual hourlies, on top of older ones.
+	ancient := &Snapshot{Name: "hourly-ancient", CreatedAt: ago(90 * 24 * time.Hour)}
+	old := &Snapshot{Name: "hourly-old", CreatedAt: ago(3 * time.Hour)}
+	recent := &Snapshot{Name: "hourly-recent", CreatedAt: ago(20 * time.Minute)}
+	burst1 := &Snapshot{Name: "hourly-burst1", CreatedAt: ago(3 * time.Minute)}
+	burst2 := &Snapshot{Name: "hourly-burst2", CreatedAt: ago(2 * time.Minute)}
+	burst3 := &Snapshot{Name: "hourly-burst3", CreatedAt: ago(time.Minute)}
+	expired := &Snapshot{Name: "daily-expired", CreatedAt: ago(60 * 24 * time.Hour)}
+	shared := &Snapshot{Name: "daily-shared", CreatedAt: ago(100 * 24 * time.Hour)}
+
+	current := NewSnapshotInventory(
+		NewSnapshots(shared, expired, ancient, old, recent, burst1, burst2, burst3),
+		NewSnapshots(shared),
+	)
+	local := Policy{
+		Counts: map[string]int{"hourly": 3, "daily": 5},
+		MinAge: time.Hour,
+		MaxAge: 30 * 24 * time.Hour,
+	}
+	remote := Policy{Counts: map[string]int{}}
+
+	target := CalculateTargetInventory(current, local, remote, now)
+
+	if !target.Local.Has(recent) {
+		t.Errorf("expected min_age to keep %s despite the burst", recent.Name)
+	}
+	if target.Local.Has(old) {
+		t.Errorf("expected %s to be pushed out by count", old.Name)
+	}
+	if target.Local.Has(ancient) {
+		t.Errorf("expected max_age to expire %s", ancient.Name)
+	}
+	if !target.Local.Has(shared) {
+		t.Errorf("expected the latest shared snapshot to outlive max_age")
+	}
+	for _, tt := range []struct {
+		snap *Snapshot
+		want Reason
+	}{
+		{recent, Reason{Keep: true, Why: "younger than min_age 1h0m0s (20m0s old)"}},
+		{old, Reason{Why: "beyond the hourly (3) quota"}},
+		{ancient, Reason{Why: "beyond the hourly (3) quota"}},
+		{expired, Reason{Why: "older than max_age 720h0m0s (1440h0m0s old)"}},
+		{shared, Reason{Keep: true, Why: "newest shared"}},
+	} {
+		if got := target.Reason(Local, tt.snap); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.snap.Name, tt.want, got)
+		}
+	}
+}
+
+func TestCalculateTargetInventoryReasons(t *testing.T) {
+	local := NewSnapshots(&Snapshot{Name: "daily-1", CreatedAt: 1}, &Snapshot{Name: "daily-2", CreatedAt: 2}, &Snapshot{Name: "daily-3", CreatedAt: 3}, &Snapshot{Name: "daily-4", CreatedAt: 4}, &Snapshot{Name: "manual-5", CreatedAt: 5})
+	remote := NewSnapshots(&Snapshot{Name: "daily-0", CreatedAt: 0}, &Snapshot{Name: "daily-1", CreatedAt: 1}, &Snapshot{Name: "daily-2", CreatedAt: 2})
+	current := NewSnapshotInventory(local, remote)
+
+	target := CalculateTargetInventory(current, Policy{Counts: map[string]int{"daily": 1}}, Policy{Counts: map[string]int{"daily": 3}}, time.Now())
+
+	for _, tt := range []struct {
+		location Location
+		snap     string
+		want     string
+	}{
+		{Local, "daily-4", "keep: daily #1 of 1"},
+		{Local, "daily-3", "keep: until sent to remote (daily #2 of 3)"},
+		{Local, "daily-2", "keep: newest shared"},
+		{Local, "daily-1", "keep: oldest local"},
+		{Local, "manual-5", "drop: policy keeps no manual snapshots"},
+		{Remote, "daily-4", "keep: daily #1 of 3"},
+		{Remote, "daily-0", "keep: oldest remote"},
+		{Remote, "daily-1", "keep: earliest shared"},
+	} {
+		var s *Snapshot
+		for candidate := range current.Local.Union(current.Remote).All() {
+			if candidate.Name == tt.snap {
+				s = candidate
+			}
+		}
+		if got := target.Reason(tt.location, s).String(); got != tt.want {
+			t.Errorf("%s %s: expected %q, got %q", tt.location, tt.snap, tt.want, got)
+		}
+	}
+}
+
+func TestCalculateTargetInventoryPinned(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) int64 { return now.Add(-d).Unix() }
+	day := 24 * time.Hour
+
+	legal := &Snapshot{Name: "manual-legal", CreatedAt: ago(400 * day)}
+	upgrade := &Snapshot{Name: "manual-upgrade", CreatedAt: ago(2 * day), Pinned: true}
+	shared := &Snapshot{Name: "daily-shared", CreatedAt: ago(3 * day)}
+	newest := &Snapshot{Name: "daily-newest", CreatedAt: ago(day)}
+
+	// The legal hold is pinned on the remote only, as pins aren't sent.
+	remoteLegal := &Snapshot{Name: "manual-legal", CreatedAt: ago(400 * day), Pinned: true}
+	current := NewSnapshotInventory(
+		NewSnapshots(legal, shared, upgrade, newest),
+		NewSnapshots(remoteLegal, shared),
+	)
+	policy := Policy{Counts: map[string]int{"daily": 1}, MaxAge: 30 * day}
+
+	target := CalculateTargetInventory(current, policy, policy, now)
+
+	for _, location := range []Location{Local, Remote} {
+		for _, s := range []*Snapshot{legal, upgrade} {
+			if got := target.Reason(location, s); got != (Reason{Keep: true, Why: "pinned"}) {
+				t.Errorf("%s %s: expected it to be pinned, got %q", location, s.Name, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package model
+
+// A GoalDiff is how switching a dataset from one goal to another, such as
+// under a proposed policy, changes what happens to its snapshots.
+type GoalDiff struct {
+	// NewlyDeleted are deleted under the new goal, but kept under the
+	// old; NoLongerDeleted the reverse.
+	NewlyDeleted    *SnapshotInventory
+	NoLongerDeleted *SnapshotInventory
+
+	// NewlyTransferred are sent to the remote under the new goal, but
+	// not under the old; NoLongerTransferred the reverse.
+	NewlyTransferred    *Snapshots
+	NoLongerTransferred *Snapshots
+}
+
+// DiffGoals compares two goals for the same current inventory.
+func DiffGoals(current, before, after *SnapshotInventory) *GoalDiff {
+	deletedBefore := NewSnapshotInventory(current.Local.Difference(before.Local), current.Remote.Difference(before.Remote))
+	deletedAfter := NewSnapshotInventory(current.Local.Difference(after.Local), current.Remote.Difference(after.Remote))
+	transferredBefore := before.Remote.Difference(current.Remote)
+	transferredAfter := after.Remote.Difference(current.Remote)
+
+	return &GoalDiff{
+		NewlyDeleted: NewSnapshotInventory(
+			deletedAfter.Local.Difference(deletedBefore.Local),
+			deletedAfter.Remote.Difference(deletedBefore.Remote),
+		),
+		NoLongerDeleted: NewSnapshotInventory(
+			deletedBefore.Local.Difference(deletedAfter.Local),
+			deletedBefore.Remote.Difference(deletedAfter.Remote),
+		),
+		NewlyTransferred:    transferredAfter.Difference(transferredBefore),
+		NoLongerTransferred: transferredBefore.Difference(transferredAfter),
+	}
+}
+
+// Empty reports whether the goals have the same effect.
+func (diff *GoalDiff) Empty() bool {
+	return diff.NewlyDeleted.Local.Len() == 0 && diff.NewlyDeleted.Remote.Len() == 0 &&
+		diff.NoLongerDeleted.Local.Len() == 0 && diff.NoLongerDeleted.Remote.Len() == 0 &&
+		diff.NewlyTransferred.Len() == 0 && diff.NoLongerTransferred.Len() == 0
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffGoals(t *testing.T) {
+	local := NewSnapshots(&Snapshot{Name: "daily-1", CreatedAt: 1}, &Snapshot{Name: "daily-2", CreatedAt: 2}, &Snapshot{Name: "daily-3", CreatedAt: 3}, &Snapshot{Name: "daily-4", CreatedAt: 4}, &Snapshot{Name: "hourly-5", CreatedAt: 5})
+	remote := NewSnapshots(&Snapshot{Name: "daily-1", CreatedAt: 1}, &Snapshot{Name: "daily-2", CreatedAt: 2}, &Snapshot{Name: "daily-3", CreatedAt: 3})
+	current := NewSnapshotInventory(local, remote)
+	now := time.Now()
+
+	before := CalculateTargetInventory(current, Policy{Counts: map[string]int{"daily": 4, "hourly": 1}}, Policy{Counts: map[string]int{"daily": 4}}, now)
+	after := CalculateTargetInventory(current, Policy{Counts: map[string]int{"daily": 4, "hourly": 1}}, Policy{Counts: map[string]int{"daily": 1, "hourly": 1}}, now)
+
+	diff := DiffGoals(current, before, after)
+	if got := diff.NewlyDeleted.Remote.Len(); got != 1 {
+		t.Errorf("expected 1 remote snapshot newly deleted, got %d:\n%s", got, diff.NewlyDeleted.Remote.Print())
+	}
+	if got := diff.NewlyDeleted.Local.Len(); got != 0 {
+		t.Errorf("expected no local changes, got %d", got)
+	}
+	if !diff.NewlyTransferred.Has(&Snapshot{Name: "hourly-5", CreatedAt: 5}) || diff.NewlyTransferred.Len() != 1 {
+		t.Errorf("expected hourly-5 to be newly transferred, got:\n%s", diff.NewlyTransferred.Print())
+	}
+	if diff.Empty() {
+		t.Errorf("expected a non-empty diff")
+	}
+	if !DiffGoals(current, before, before).Empty() {
+		t.Errorf("expected identical goals to have an empty diff")
+	}
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"maps"
 	"strings"
 )
 
@@ -11,6 +12,9 @@ import (
 type SnapshotInventory struct {
 	Local  *Snapshots
 	Remote *Snapshots
+
+	// reasons explains a goal inventory; see Keep, Drop, and Reason.
+	reasons map[Location]map[string]Reason
 }
 
 // NewSnapshotInventory creates a new SnapshotInventory with the given local and remote snapshots
@@ -26,10 +30,17 @@ func (si *SnapshotInventory) Clone() *SnapshotInventory {
 	if si == nil {
 		return nil
 	}
-	return &SnapshotInventory{
+	out := &SnapshotInventory{
 		Local:  si.Local.Clone(),
 		Remote: si.Remote.Clone(),
 	}
+	if si.reasons != nil {
+		out.reasons = make(map[Location]map[string]Reason, len(si.reasons))
+		for location, reasons := range si.reasons {
+			out.reasons[location] = maps.Clone(reasons)
+		}
+	}
+	return out
 }
 
 // Eq checks if two SnapshotInventories are equal
@@ -84,3 +95,15 @@ func (si *SnapshotInventory) RemoteString() string {
 	}
 	return si.Remote.String()
 }
+
+// Pinned reports whether the snapshot is pinned on either side. Pins aren't
+// sent along with snapshots, so a snapshot pinned on one side is kept on
+// both.
+func (si *SnapshotInventory) Pinned(snap *Snapshot) bool {
+	for _, snaps := range []*Snapshots{si.Local, si.Remote} {
+		if got := snaps.Get(snap); got != nil && got.Pinned {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeletionLimit caps how many snapshots a single plan may delete at one
+// location, as a count and as a share of the snapshots there. Zero values
+// mean no limit.
+type DeletionLimit struct {
+	Max      int
+	MaxShare float64
+}
+
+// Check returns why deleting n of total snapshots at the given location
+// exceeds the limit, or "" if it doesn't.
+func (limit DeletionLimit) Check(location Location, n, total int) string {
+	loc := strings.ToLower(location.String())
+	if limit.Max > 0 && n > limit.Max {
+		return fmt.Sprintf("plan deletes %d of %d %s snapshots, more than %s.max_deletions (%d)",
+			n, total, loc, loc, limit.Max)
+	}
+	if limit.MaxShare > 0 && total > 0 && float64(n)/float64(total) > limit.MaxShare {
+		return fmt.Sprintf("plan deletes %d of %d %s snapshots, more than %s.max_deletion_share (%g)",
+			n, total, loc, loc, limit.MaxShare)
+	}
+	return ""
+}
+
+// HoldExcessiveDeletions holds the plan's deletions for approval if going
+// from current to target deletes more snapshots at either location than its
+// limit allows. It guards against a renamed snapshot type or a bad policy
+// suddenly matching nothing. Transfers may still run meanwhile, unless the
+// whole plan was already held.
+func HoldExcessiveDeletions(plan *Plan, current, target *SnapshotInventory, localLimit, remoteLimit DeletionLimit) {
+	reasons := []string{}
+	heldWhole := plan.NeedsApproval != "" && !plan.HoldsOnlyDeletions
+	if plan.NeedsApproval != "" {
+		reasons = append(reasons, plan.NeedsApproval)
+	}
+	if reason := localLimit.Check(Local, current.Local.Difference(target.Local).Len(), current.Local.Len()); reason != "" {
+		reasons = append(reasons, reason)
+	}
+	if reason := remoteLimit.Check(Remote, current.Remote.Difference(target.Remote).Len(), current.Remote.Len()); reason != "" {
+		reasons = append(reasons, reason)
+	}
+	plan.NeedsApproval = strings.Join(reasons, "; ")
+	plan.HoldsOnlyDeletions = plan.NeedsApproval != "" && !heldWhole
+}
+
+// DeferHeldDeletions rewrites a plan that holds only its deletions so that
+// its other steps come first, and its deletions are those left once they've
+// run, calculated with targetFor. Those are the deletions the following
+// cycle plans, so an approval given for this plan's fingerprint still
+// applies then, when the base that its transfers replace is deletable too.
+// It returns the target the rewritten plan reaches.
+func DeferHeldDeletions(plan *Plan, current, target *SnapshotInventory, targetFor func(*SnapshotInventory) *SnapshotInventory, localLimit, remoteLimit DeletionLimit) (*SnapshotInventory, *Plan, error) {
+	if !plan.HoldsOnlyDeletions {
+		return target, plan, nil
+	}
+
+	var ops []Operation
+	after := current
+	for _, step := range plan.Steps {
+		if IsDeletion(step) {
+			continue
+		}
+		next, err := step.Apply(after)
+		if err != nil {
+			return nil, nil, fmt.Errorf("applying '%s': %w", step, err)
+		}
+		ops, after = append(ops, step.Operation), next
+	}
+
+	deferredTarget := targetFor(after)
+	rest, err := CalculateTransitionPlan(after, deferredTarget)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, step := range rest.Steps {
+		ops = append(ops, step.Operation)
+	}
+
+	deferred := PlanFromOperations(ops)
+	HoldExcessiveDeletions(deferred, current, deferredTarget, localLimit, remoteLimit)
+	return deferredTarget, deferred, nil
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHoldExcessiveDeletions(t *testing.T) {
+	var snaps []*Snapshot
+	for i := range int64(10) {
+		snaps = append(snaps, &Snapshot{Name: "daily-" + string(rune('a'+i)), CreatedAt: i})
+	}
+	fresh := &Snapshot{Name: "nightly-k", CreatedAt: 10}
+	current := NewSnapshotInventory(NewSnapshots(append(snaps, fresh)...), NewSnapshots(snaps...))
+
+	// Snapshots were renamed, so the policy matches none of them but the
+	// newest, which is yet to be sent.
+	policy := Policy{Counts: map[string]int{"nightly": 7}}
+	target := CalculateTargetInventory(current, policy, policy, time.Now())
+
+	for _, tt := range []struct {
+		name   string
+		limit  DeletionLimit
+		reason string
+	}{
+		{"no limit", DeletionLimit{}, ""},
+		{"under the count", DeletionLimit{Max: 9}, ""},
+		{"over the count", DeletionLimit{Max: 5}, "remote.max_deletions (5)"},
+		{"over the share", DeletionLimit{MaxShare: 0.5}, "remote.max_deletion_share (0.5)"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := CalculateTransitionPlan(current, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			HoldExcessiveDeletions(plan, current, target, DeletionLimit{}, tt.limit)
+			if tt.reason == "" && plan.Held() {
+				t.Errorf("unexpected hold: %s", plan.NeedsApproval)
+			}
+			if tt.reason != "" && !strings.Contains(plan.NeedsApproval, tt.reason) {
+				t.Errorf("expected hold for %q, got %q", tt.reason, plan.NeedsApproval)
+			}
+			for _, step := range plan.Steps {
+				if held := tt.reason != "" && IsDeletion(step); plan.HeldStep(step) != held {
+					t.Errorf("expected %s to be held: %t", step, held)
+				}
+			}
+		})
+	}
+
+	t.Run("already held", func(t *testing.T) {
+		plan, err := CalculateTransitionPlan(current, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.NeedsApproval = "reseed"
+		HoldExcessiveDeletions(plan, current, target, DeletionLimit{}, DeletionLimit{Max: 5})
+		for _, step := range plan.Steps {
+			if !plan.HeldStep(step) {
+				t.Errorf("expected %s to stay held with the whole plan", step)
+			}
+		}
+	})
+}
+
+func TestDeferHeldDeletions(t *testing.T) {
+	var snaps []*Snapshot
+	for i := range int64(10) {
+		snaps = append(snaps, &Snapshot{Name: "daily-" + string(rune('a'+i)), CreatedAt: i})
+	}
+	fresh := &Snapshot{Name: "nightly-k", CreatedAt: 10}
+	current := NewSnapshotInventory(NewSnapshots(append(snaps, fresh)...), NewSnapshots(snaps...))
+
+	policy := Policy{Counts: map[string]int{"nightly": 7}}
+	now := time.Now()
+	targetFor := func(current *SnapshotInventory) *SnapshotInventory {
+		return CalculateTargetInventory(current, policy, policy, now)
+	}
+	limit := DeletionLimit{Max: 5}
+	cycle := func(current *SnapshotInventory) (*SnapshotInventory, *Plan) {
+		t.Helper()
+		target := targetFor(current)
+		plan, err := CalculateTransitionPlan(current, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		HoldExcessiveDeletions(plan, current, target, DeletionLimit{}, limit)
+		target, plan, err = DeferHeldDeletions(plan, current, target, targetFor, DeletionLimit{}, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidatePlan(t.Context(), current, target, plan, false); err != nil {
+			t.Fatal(err)
+		}
+		return target, plan
+	}
+	apply := func(step *PlanStep) {
+		t.Helper()
+		next, err := step.Apply(current)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current = next
+	}
+
+	// The deletions are approved while held, and the transfer runs.
+	_, held := cycle(current)
+	if !held.Held() || !held.HoldsOnlyDeletions {
+		t.Fatalf("expected the deletions to be held, got %q", held.NeedsApproval)
+	}
+	approved := held.Fingerprint()
+	for _, step := range held.Steps {
+		if !held.HeldStep(step) {
+			apply(step)
+		}
+	}
+
+	// The next cycle plans only the deletions, under the same
+	// fingerprint, including the base the transfer replaced.
+	target, plan := cycle(current)
+	if got := plan.Fingerprint(); got != approved {
+		t.Fatalf("expected the approved fingerprint %s, got %s", approved, got)
+	}
+	plan.Approved = true
+	for _, step := range plan.Steps {
+		if !IsDeletion(step) || plan.HeldStep(step) {
+			t.Errorf("expected only approved deletions, got %s", step)
+		}
+		apply(step)
+	}
+	if !current.Eq(target) {
+		t.Errorf("expected the approved plan to reach its target")
+	}
+	if current.Remote.Has(snaps[9]) {
+		t.Errorf("expected the old base to be deleted")
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"slices"
 	"sort"
 
 	"monks.co/backupd/logger"
@@ -8,6 +9,12 @@ import (
 
 type Model struct {
 	Datasets map[DatasetName]*Dataset
+	Restores []*Restore
+	Target   *Target // Removable remote pool, if configured
+
+	// Warnings are problems that need someone to look at them, such as
+	// operations interrupted by a crash that left zfs in an unclear state.
+	Warnings []string
 }
 
 func New() *Model {
@@ -22,6 +29,11 @@ func (model *Model) Clone() *Model {
 		for k, ds := range model.Datasets {
 			out.Datasets[k] = ds.Clone()
 		}
+		for _, r := range model.Restores {
+			out.Restores = append(out.Restores, r.Clone())
+		}
+		out.Target = model.Target.Clone()
+		out.Warnings = slices.Clone(model.Warnings)
 	}
 	return out
 }
@@ -53,6 +65,20 @@ func (model *Model) SetPlan(dataset DatasetName, plan *Plan) {
 	ds.Plan = plan
 }
 
+func AddWarnings(warnings ...string) func(*Model) *Model {
+	return func(old *Model) *Model {
+		out := old.Clone()
+		out.Warnings = append(out.Warnings, warnings...)
+		return out
+	}
+}
+
+func ClearWarnings(old *Model) *Model {
+	out := old.Clone()
+	out.Warnings = nil
+	return out
+}
+
 func ReplaceDataset(name DatasetName, dataset *Dataset) func(*Model) *Model {
 	return func(old *Model) *Model {
 		out := old.Clone()
@@ -9,6 +9,47 @@ type Operation interface {
 	Apply(*SnapshotInventory) (*SnapshotInventory, error)
 }
 
+// OperationKind names the operation's type, for grouping operations in
+// history and metrics, e.g. "range_transfer".
+func OperationKind(op Operation) string {
+	if step, ok := op.(*PlanStep); ok {
+		op = step.Operation
+	}
+	switch op.(type) {
+	case *SnapshotDeletion:
+		return "deletion"
+	case *SnapshotRangeDeletion:
+		return "range_deletion"
+	case *InitialSnapshotTransfer:
+		return "initial_transfer"
+	case *SnapshotTransfer:
+		return "transfer"
+	case *SnapshotRangeTransfer:
+		return "range_transfer"
+	case *RemoteDatasetSetAside:
+		return "set_aside"
+	case *InitialSnapshotRestore:
+		return "initial_restore"
+	case *SnapshotRangeRestore:
+		return "range_restore"
+	default:
+		return "unknown"
+	}
+}
+
+// IsDeletion reports whether the operation deletes snapshots.
+func IsDeletion(op Operation) bool {
+	if step, ok := op.(*PlanStep); ok {
+		op = step.Operation
+	}
+	switch op.(type) {
+	case *SnapshotDeletion, *SnapshotRangeDeletion:
+		return true
+	default:
+		return false
+	}
+}
+
 var _ Operation = &SnapshotRangeDeletion{}
 
 type SnapshotRangeDeletion struct {
@@ -2,6 +2,9 @@ package model
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -19,12 +22,29 @@ const (
 	StepFailed
 )
 
+func (status StepStatus) String() string {
+	switch status {
+	case StepPending:
+		return "pending"
+	case StepInProgress:
+		return "in_progress"
+	case StepCompleted:
+		return "completed"
+	case StepFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
 // PlanStep wraps an Operation with its execution status
 type PlanStep struct {
 	Operation
 	Status    StepStatus
 	StartedAt *time.Time
 	StoppedAt *time.Time
+	Progress  *TransferProgress // Latest progress, for transfers
+	Checksum  string            // Verified sha256 of the stream, for transfers
 	Logs      *logger.Logger
 }
 
@@ -36,6 +56,45 @@ func (ps *PlanStep) Duration() time.Duration {
 	return ps.StoppedAt.Sub(*ps.StartedAt)
 }
 
+// Clone copies the plan and its steps. Step logs are shared.
+func (plan *Plan) Clone() *Plan {
+	if plan == nil {
+		return nil
+	}
+	steps := make([]*PlanStep, len(plan.Steps))
+	for i, step := range plan.Steps {
+		steps[i] = &PlanStep{
+			Operation: step.Operation,
+			Status:    step.Status,
+			StartedAt: step.StartedAt,
+			StoppedAt: step.StoppedAt,
+			Progress:  step.Progress,
+			Checksum:  step.Checksum,
+			Logs:      step.Logs, // ProcessLogs is a pointer, share the same logs
+		}
+	}
+	return &Plan{
+		Steps:              steps,
+		NeedsApproval:      plan.NeedsApproval,
+		HoldsOnlyDeletions: plan.HoldsOnlyDeletions,
+		HeldBack:           plan.HeldBack,
+		Approved:           plan.Approved,
+	}
+}
+
+// ActiveStep returns the step currently being executed, or nil.
+func (plan *Plan) ActiveStep() *PlanStep {
+	if plan == nil {
+		return nil
+	}
+	for _, step := range plan.Steps {
+		if step.Status == StepInProgress {
+			return step
+		}
+	}
+	return nil
+}
+
 // Verify PlanStep implements Operation
 var _ Operation = &PlanStep{}
 
@@ -52,6 +111,47 @@ func (ps *PlanStep) Apply(inv *SnapshotInventory) (*SnapshotInventory, error) {
 // Plan is a sequence of plan steps with plan-level logging
 type Plan struct {
 	Steps []*PlanStep
+
+	// NeedsApproval, if set, says why the plan must not be executed
+	// until someone approves it.
+	NeedsApproval string
+
+	// HoldsOnlyDeletions says that only the plan's deletions wait for
+	// approval; its other steps may run meanwhile.
+	HoldsOnlyDeletions bool
+
+	// HeldBack, if set, says why the plan leaves out steps it would
+	// otherwise have, such as an initial transfer waiting for a seed.
+	// Unlike NeedsApproval, it isn't lifted by approving the plan.
+	HeldBack string
+
+	Approved bool
+}
+
+// Held reports whether the plan is waiting for approval.
+func (plan *Plan) Held() bool {
+	return plan != nil && plan.NeedsApproval != "" && !plan.Approved
+}
+
+// HeldStep reports whether the given step of the plan is waiting for
+// approval.
+func (plan *Plan) HeldStep(step *PlanStep) bool {
+	return plan.Held() && (!plan.HoldsOnlyDeletions || IsDeletion(step))
+}
+
+// Fingerprint identifies the plan's operations, so that an approval given
+// for one plan isn't applied to a different one. For a plan that holds only
+// its deletions, it identifies just those, so that the approval still
+// applies once the other steps have run.
+func (plan *Plan) Fingerprint() string {
+	hash := sha256.New()
+	for _, step := range plan.Steps {
+		if plan.HoldsOnlyDeletions && !IsDeletion(step) {
+			continue
+		}
+		fmt.Fprintln(hash, step.String())
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
 }
 
 // NewPlanStep creates a new plan step with pending status
@@ -99,6 +199,11 @@ func PlanFromOperations(ops []Operation) *Plan {
 	}
 }
 
+// ErrNoSharedSnapshot is returned by CalculateTransitionPlan when the remote
+// has snapshots but none of them exist locally, so there is no base for an
+// incremental transfer. See CalculateReseedPlan.
+var ErrNoSharedSnapshot = errors.New("remote has data, but none is shared with local")
+
 func CalculateTransitionPlan(current, target *SnapshotInventory) (*Plan, error) {
 	var ops []Operation
 
@@ -148,7 +253,7 @@ func CalculateTransitionPlan(current, target *SnapshotInventory) (*Plan, error)
 	// if there is no shared snapshot, but there are remote snapshots, error
 	last := sharedSnapshots.Newest()
 	if last == nil && current.Remote.Len() > 0 {
-		return nil, fmt.Errorf("remote has data, but none is shared with local")
+		return nil, ErrNoSharedSnapshot
 	}
 	if current.Remote.Len() == 0 {
 		ops = append(ops, &InitialSnapshotTransfer{
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// A Policy decides which snapshots a location keeps.
+type Policy struct {
+	// Counts is how many of the newest snapshots of each type to keep.
+	Counts map[string]int
+
+	// Tiers orders snapshot types from highest to lowest, e.g. yearly,
+	// monthly, weekly, daily, hourly. A snapshot counts toward the quota
+	// of its own type and of every lower tier, so that a yearly snapshot
+	// also fills a daily slot.
+	Tiers []string
+
+	// MinAge, if set, keeps every snapshot younger than it, whatever the
+	// counts.
+	MinAge time.Duration
+
+	// MaxAge, if set, expires every snapshot older than it, whatever the
+	// counts. Only the newest snapshot shared between local and remote
+	// outlives it, since the next incremental transfer is based on it.
+	MaxAge time.Duration
+}
+
+// Young reports whether the snapshot is protected by MinAge.
+func (policy Policy) Young(snap *Snapshot, now time.Time) bool {
+	return policy.MinAge > 0 && now.Sub(snap.Time()) < policy.MinAge
+}
+
+// Expired reports whether the snapshot is past MaxAge.
+func (policy Policy) Expired(snap *Snapshot, now time.Time) bool {
+	return policy.MaxAge > 0 && now.Sub(snap.Time()) > policy.MaxAge
+}
+
+// youngWhy explains why MinAge keeps the snapshot.
+func (policy Policy) youngWhy(snap *Snapshot, now time.Time) string {
+	return fmt.Sprintf("younger than min_age %s (%s old)", policy.MinAge, now.Sub(snap.Time()).Truncate(time.Minute))
+}
+
+// expiredWhy explains why MaxAge expires the snapshot.
+func (policy Policy) expiredWhy(snap *Snapshot, now time.Time) string {
+	return fmt.Sprintf("older than max_age %s (%s old)", policy.MaxAge, now.Sub(snap.Time()).Truncate(time.Minute))
+}
+
+// Quotas returns the types whose quotas a snapshot of the given type counts
+// toward: its own, and every tier below it.
+func (policy Policy) Quotas(typ string) []string {
+	i := slices.Index(policy.Tiers, typ)
+	if i < 0 {
+		return []string{typ}
+	}
+	return policy.Tiers[i:]
+}
+
+// PolicyKeepingAll returns a policy which keeps every one of the given
+// snapshots. It's the local policy for replicas, whose snapshots are
+// managed by the upstream that sends them.
+func PolicyKeepingAll(snapshots *Snapshots) Policy {
+	counts := map[string]int{}
+	for snap := range snapshots.All() {
+		counts[snap.Type()]++
+	}
+	return Policy{Counts: counts}
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// TransferProgress is a point-in-time view of a running transfer.
+type TransferProgress struct {
+	BytesDone   int64
+	TotalBytes  int64         // Estimated stream size; may be 0 if unknown
+	CurrentRate float64       // Bytes per second over the last minute
+	AverageRate float64       // Bytes per second since the transfer started
+	ETA         time.Duration // 0 if it can't be estimated
+	UpdatedAt   time.Time
+}
+
+// Percent returns how much of the estimated size has been transferred, from
+// 0 to 100. Estimates from `zfs send --dryrun` are not exact, so the result
+// is clamped at 100.
+func (tp *TransferProgress) Percent() float64 {
+	if tp == nil || tp.TotalBytes <= 0 {
+		return 0
+	}
+	pct := float64(tp.BytesDone) / float64(tp.TotalBytes) * 100
+	if pct > 100 {
+		return 100
+	}
+	return pct
+}
+
+// PercentString returns the completion percentage, or "-" if unknown
+func (tp *TransferProgress) PercentString() string {
+	if tp == nil || tp.TotalBytes <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", tp.Percent())
+}
+
+// BytesString returns e.g. "1.2 GB of 4.0 GB"
+func (tp *TransferProgress) BytesString() string {
+	if tp == nil {
+		return "-"
+	}
+	if tp.TotalBytes <= 0 {
+		return humanize.Bytes(uint64(tp.BytesDone))
+	}
+	return fmt.Sprintf("%s of %s", humanize.Bytes(uint64(tp.BytesDone)), humanize.Bytes(uint64(tp.TotalBytes)))
+}
+
+// CurrentRateString returns the last-minute throughput in bits per second
+func (tp *TransferProgress) CurrentRateString() string {
+	if tp == nil {
+		return "-"
+	}
+	return rateString(tp.CurrentRate)
+}
+
+// AverageRateString returns the whole-transfer throughput in bits per second
+func (tp *TransferProgress) AverageRateString() string {
+	if tp == nil {
+		return "-"
+	}
+	return rateString(tp.AverageRate)
+}
+
+// ETAString returns the estimated time remaining, or "-" if unknown
+func (tp *TransferProgress) ETAString() string {
+	if tp == nil || tp.ETA <= 0 {
+		return "-"
+	}
+	return tp.ETA.Truncate(time.Second).String()
+}
+
+func (tp *TransferProgress) String() string {
+	if tp == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s %s (now %s, avg %s, eta %s)",
+		tp.PercentString(), tp.BytesString(), tp.CurrentRateString(), tp.AverageRateString(), tp.ETAString())
+}
+
+func rateString(bytesPerSecond float64) string {
+	return humanize.SIWithDigits(bytesPerSecond*8, 1, "bps")
+}
@@ -0,0 +1,74 @@
+package model
+
+// A Reason says why a goal keeps or drops a snapshot at a location.
+type Reason struct {
+	Keep bool
+	Why  string
+}
+
+func (reason Reason) String() string {
+	verdict := "drop"
+	if reason.Keep {
+		verdict = "keep"
+	}
+	if reason.Why == "" {
+		return verdict
+	}
+	return verdict + ": " + reason.Why
+}
+
+// Keep adds the snapshot to the goal at the given location, recording why.
+// A snapshot kept by several rules is explained by the first of them.
+func (si *SnapshotInventory) Keep(location Location, snap *Snapshot, why string) {
+	snaps := si.at(location)
+	if snaps == nil {
+		return
+	}
+	if reason, ok := si.reasons[location][snap.ID()]; !ok || !reason.Keep {
+		si.setReason(location, snap, Reason{Keep: true, Why: why})
+	}
+	snaps.Add(snap)
+}
+
+// Drop records why the goal doesn't keep the snapshot at the given
+// location, unless something already keeps it. Later reasons to drop a
+// snapshot replace earlier ones, as they're more specific.
+func (si *SnapshotInventory) Drop(location Location, snap *Snapshot, why string) {
+	if si.at(location).Has(snap) {
+		return
+	}
+	si.setReason(location, snap, Reason{Why: why})
+}
+
+// Reason returns why the goal keeps or drops the snapshot at the given
+// location. Goals that weren't explained only say whether it's kept.
+func (si *SnapshotInventory) Reason(location Location, snap *Snapshot) Reason {
+	if reason, ok := si.reasons[location][snap.ID()]; ok {
+		return reason
+	}
+	return Reason{Keep: si.at(location).Has(snap)}
+}
+
+func (si *SnapshotInventory) setReason(location Location, snap *Snapshot, reason Reason) {
+	if si.reasons == nil {
+		si.reasons = map[Location]map[string]Reason{}
+	}
+	if si.reasons[location] == nil {
+		si.reasons[location] = map[string]Reason{}
+	}
+	si.reasons[location][snap.ID()] = reason
+}
+
+func (si *SnapshotInventory) at(location Location) *Snapshots {
+	if si == nil {
+		return nil
+	}
+	switch location {
+	case Local:
+		return si.Local
+	case Remote:
+		return si.Remote
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// orphanedInfix marks remote datasets which were set aside by a reseed.
+const orphanedInfix = ".orphaned-"
+
+// OrphanedName returns the name a remote dataset is renamed to when it's set
+// aside by a reseed. It's named for the day of the remote's newest snapshot,
+// not the day it's set aside, so that a held reseed plan, and so its
+// fingerprint, stays the same from one day to the next.
+func OrphanedName(dataset DatasetName, newest *Snapshot) DatasetName {
+	at := time.Unix(newest.CreatedAt, 0).UTC()
+	return DatasetName(fmt.Sprintf("%s%s%s", dataset, orphanedInfix, at.Format("2006-01-02")))
+}
+
+// IsOrphaned reports whether the dataset is, or is inside, a remote dataset
+// which was set aside by a reseed.
+func (dn DatasetName) IsOrphaned() bool {
+	for part := range strings.SplitSeq(dn.Path(), "/") {
+		if strings.Contains(part, orphanedInfix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CalculateReseedPlan plans recovering a dataset whose remote copy shares no
+// snapshots with local (see ErrNoSharedSnapshot): the remote dataset is
+// renamed to `aside`, and local is sent to the remote from scratch. It
+// returns the target inventory along with the plan. The plan always needs
+// approval.
+func CalculateReseedPlan(dataset DatasetName, current *SnapshotInventory, aside DatasetName, localPolicy, remotePolicy Policy, now time.Time) (*SnapshotInventory, *Plan, error) {
+	if dataset == "" {
+		return nil, nil, fmt.Errorf("the root dataset can't be set aside")
+	}
+
+	emptied := NewSnapshotInventory(current.Local.Clone(), NewSnapshots())
+	target := CalculateTargetInventory(emptied, localPolicy, remotePolicy, now)
+	rest, err := CalculateTransitionPlan(emptied, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("planning transfer after setting aside: %w", err)
+	}
+
+	ops := []Operation{&RemoteDatasetSetAside{Dataset: dataset, To: aside}}
+	for _, step := range rest.Steps {
+		ops = append(ops, step.Operation)
+	}
+	plan := PlanFromOperations(ops)
+	plan.NeedsApproval = fmt.Sprintf("remote shares no snapshots with local; its %d snapshots will be set aside as %s and local sent from scratch",
+		current.Remote.Len(), aside)
+
+	return target, plan, nil
+}
+
+var _ Operation = &RemoteDatasetSetAside{}
+
+// RemoteDatasetSetAside renames a remote dataset out of the way, leaving
+// the original name free for a fresh initial transfer.
+type RemoteDatasetSetAside struct {
+	Dataset DatasetName
+	To      DatasetName
+}
+
+func (op *RemoteDatasetSetAside) String() string {
+	return fmt.Sprintf("set aside remote %s as %s", op.Dataset, op.To)
+}
+
+func (op *RemoteDatasetSetAside) Apply(inv *SnapshotInventory) (*SnapshotInventory, error) {
+	if inv.Remote.Len() == 0 {
+		return nil, fmt.Errorf("nothing to set aside: remote %s has no snapshots", op.Dataset)
+	}
+
+	out := inv.Clone()
+	out.Remote = NewSnapshots()
+
+	return out, nil
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCalculateReseedPlan(t *testing.T) {
+	local1 := &Snapshot{Dataset: "/home", Name: "daily-1", CreatedAt: 1}
+	local2 := &Snapshot{Dataset: "/home", Name: "daily-2", CreatedAt: 2}
+	orphan := &Snapshot{Dataset: "/home", Name: "daily-0", CreatedAt: 0}
+	policy := Policy{Counts: map[string]int{"daily": 5}}
+
+	current := NewSnapshotInventory(NewSnapshots(local1, local2), NewSnapshots(orphan))
+	target := CalculateTargetInventory(current, policy, policy, time.Now())
+	if _, err := CalculateTransitionPlan(current, target); !errors.Is(err, ErrNoSharedSnapshot) {
+		t.Fatalf("expected ErrNoSharedSnapshot, got %v", err)
+	}
+
+	aside := OrphanedName("/home", &Snapshot{Dataset: "/home", Name: "daily-9", CreatedAt: time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC).Unix()})
+	if aside != "/home.orphaned-2024-01-02" || !aside.IsOrphaned() {
+		t.Fatalf("unexpected set-aside name %s", aside)
+	}
+
+	target, plan, err := CalculateReseedPlan("/home", current, aside, policy, policy, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan.Held() {
+		t.Errorf("expected reseed plan to need approval")
+	}
+	if _, ok := plan.Steps[0].Operation.(*RemoteDatasetSetAside); !ok {
+		t.Errorf("expected plan to start by setting aside, got %s", plan.Steps[0])
+	}
+	if err := ValidatePlan(context.Background(), current, target, plan, false); err != nil {
+		t.Errorf("plan does not validate: %v", err)
+	}
+	if !target.Remote.Has(local1) || !target.Remote.Has(local2) || target.Remote.Has(orphan) {
+		t.Errorf("unexpected remote target %s", target.Remote.Print())
+	}
+
+	if _, _, err := CalculateReseedPlan("", current, aside, policy, policy, time.Now()); err == nil {
+		t.Errorf("expected error setting aside the root dataset")
+	}
+}
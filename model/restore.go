@@ -0,0 +1,169 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"monks.co/backupd/logger"
+)
+
+// A Restore is a job which pulls a snapshot back from the remote into a
+// local dataset. Unlike dataset plans, restores are requested by hand, and
+// they survive the hourly refresh.
+type Restore struct {
+	ID        int
+	Dataset   DatasetName // Source dataset, on the remote
+	Snapshot  string      // Snapshot to restore
+	To        DatasetName // Destination dataset, on local
+	Status    StepStatus
+	Error     string
+	CreatedAt time.Time
+	Plan      *Plan
+	Logs      *logger.Logger
+}
+
+func (r *Restore) String() string {
+	if r.To == r.Dataset {
+		return fmt.Sprintf("restore %s@%s", r.Dataset, r.Snapshot)
+	}
+	return fmt.Sprintf("restore %s@%s to %s", r.Dataset, r.Snapshot, r.To)
+}
+
+func (r *Restore) Clone() *Restore {
+	out := *r
+	out.Plan = r.Plan.Clone()
+	return &out
+}
+
+func AddRestore(restore *Restore) func(*Model) *Model {
+	return func(old *Model) *Model {
+		out := old.Clone()
+		out.Restores = append(out.Restores, restore)
+		return out
+	}
+}
+
+func ReplaceRestore(restore *Restore) func(*Model) *Model {
+	return func(old *Model) *Model {
+		out := old.Clone()
+		for i, r := range out.Restores {
+			if r.ID == restore.ID {
+				out.Restores[i] = restore
+			}
+		}
+		return out
+	}
+}
+
+// GetRestore returns the restore with the given ID, or nil.
+func (model *Model) GetRestore(id int) *Restore {
+	for _, r := range model.Restores {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// CalculateRestorePlan plans bringing `snapshot` from the remote into the
+// local side of `current`. The local side of `current` holds the
+// destination's snapshots, attributed to the source dataset (see
+// Snapshot.InDataset), so that they can be matched with the remote's.
+//
+// If the destination is empty, the snapshot is restored in full. Otherwise,
+// the destination's newest snapshot must also exist on the remote, and the
+// restore is incremental from there.
+func CalculateRestorePlan(current *SnapshotInventory, snapshot *Snapshot, to DatasetName) (*Plan, error) {
+	if !current.Remote.Has(snapshot) {
+		return nil, fmt.Errorf("remote doesn't have %s", snapshot)
+	}
+	if current.Local.Has(snapshot) {
+		return nil, fmt.Errorf("local already has %s", snapshot)
+	}
+
+	base := current.Local.Newest()
+	if base == nil {
+		return PlanFromOperations([]Operation{
+			&InitialSnapshotRestore{Snapshot: snapshot, To: to},
+		}), nil
+	}
+
+	if !current.Remote.Has(base) {
+		return nil, fmt.Errorf("local's newest snapshot %s is not on the remote; restore to a new dataset instead", base)
+	}
+	if base.CreatedAt >= snapshot.CreatedAt {
+		return nil, fmt.Errorf("local's newest snapshot %s is newer than %s; restore to a new dataset instead", base, snapshot)
+	}
+
+	return PlanFromOperations([]Operation{
+		&SnapshotRangeRestore{Start: base, End: snapshot, To: to},
+	}), nil
+}
+
+var _ Operation = &InitialSnapshotRestore{}
+
+// InitialSnapshotRestore pulls a full copy of a remote snapshot into an
+// empty local dataset.
+type InitialSnapshotRestore struct {
+	Snapshot *Snapshot
+	To       DatasetName
+}
+
+func (op *InitialSnapshotRestore) String() string {
+	return fmt.Sprintf("restore initial %s to %s", op.Snapshot, op.To)
+}
+
+func (op *InitialSnapshotRestore) Apply(inv *SnapshotInventory) (*SnapshotInventory, error) {
+	if inv.Local.Len() > 0 {
+		return nil, fmt.Errorf("too late for initial restore of %s, local already has %d snapshots",
+			op.Snapshot, inv.Local.Len())
+	}
+	if !inv.Remote.Has(op.Snapshot) {
+		return nil, fmt.Errorf("remote doesn't have %s", op.Snapshot)
+	}
+
+	out := inv.Clone()
+	if out.Local == nil {
+		out.Local = NewSnapshots()
+	}
+	out.Local.Add(op.Snapshot)
+
+	return out, nil
+}
+
+var _ Operation = &SnapshotRangeRestore{}
+
+// SnapshotRangeRestore pulls the changes between two remote snapshots into
+// a local dataset whose newest snapshot is the first of them.
+type SnapshotRangeRestore struct {
+	Start *Snapshot
+	End   *Snapshot
+	To    DatasetName
+}
+
+func (op *SnapshotRangeRestore) String() string {
+	return fmt.Sprintf("restore range from %s to %s into %s", op.Start, op.End.Name, op.To)
+}
+
+func (op *SnapshotRangeRestore) Apply(inv *SnapshotInventory) (*SnapshotInventory, error) {
+	if op.Start.Eq(op.End) {
+		return nil, fmt.Errorf("invalid range (same start and end)")
+	}
+	if op.Start.CreatedAt >= op.End.CreatedAt {
+		return nil, fmt.Errorf("invalid range %s to %s", op.Start, op.End)
+	}
+	if !op.Start.Eq(inv.Local.Newest()) {
+		return nil, fmt.Errorf("cannot restore from %s: newest on local is %s", op.Start, inv.Local.Newest())
+	}
+	if !inv.Remote.Has(op.Start) {
+		return nil, fmt.Errorf("remote doesn't have range-start %s", op.Start)
+	}
+	if !inv.Remote.Has(op.End) {
+		return nil, fmt.Errorf("remote doesn't have range-end %s", op.End)
+	}
+
+	out := inv.Clone()
+	out.Local.Add(op.End)
+
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCalculateRestorePlan(t *testing.T) {
+	snap1 := &Snapshot{Dataset: "/home", Name: "daily-1", CreatedAt: 1}
+	snap2 := &Snapshot{Dataset: "/home", Name: "daily-2", CreatedAt: 2}
+	snap3 := &Snapshot{Dataset: "/home", Name: "daily-3", CreatedAt: 3}
+	localOnly := &Snapshot{Dataset: "/home", Name: "hourly-4", CreatedAt: 4}
+
+	for _, tc := range []struct {
+		name    string
+		local   *Snapshots
+		restore *Snapshot
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "empty destination",
+			local:   NewSnapshots(),
+			restore: snap2,
+			want:    "restore initial /home@daily-2 to /home",
+		},
+		{
+			name:    "shared base",
+			local:   NewSnapshots(snap1),
+			restore: snap3,
+			want:    "restore range from /home@daily-1 to daily-3 into /home",
+		},
+		{
+			name:    "already present",
+			local:   NewSnapshots(snap1, snap2),
+			restore: snap2,
+			wantErr: true,
+		},
+		{
+			name:    "local newest not on remote",
+			local:   NewSnapshots(snap1, localOnly),
+			restore: snap3,
+			wantErr: true,
+		},
+		{
+			name:    "local newest is newer",
+			local:   NewSnapshots(snap1, snap3),
+			restore: snap2,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			current := NewSnapshotInventory(tc.local, NewSnapshots(snap1, snap2, snap3))
+			plan, err := CalculateRestorePlan(current, tc.restore, "/home")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got plan %v", plan.Steps)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(plan.Steps) != 1 || plan.Steps[0].String() != tc.want {
+				t.Fatalf("expected plan [%s], got %v", tc.want, plan.Steps)
+			}
+
+			target := current.Clone()
+			target.Local.Add(tc.restore)
+			if err := ValidatePlan(context.Background(), current, target, plan, false); err != nil {
+				t.Errorf("plan does not validate: %v", err)
+			}
+		})
+	}
+}
@@ -13,6 +13,9 @@ type Snapshot struct {
 	Name              string
 	CreatedAt         int64
 	LogicalReferenced int64 // Logical size of dataset at this snapshot (w/o children)
+	Used              int64 // Space freed by destroying only this snapshot
+	Written           int64 // Space written between the previous snapshot and this one
+	Pinned            bool  // Exempt from retention, by the backupd:pin user property
 }
 
 func (snap *Snapshot) ID() string {
@@ -62,3 +65,11 @@ func (snap *Snapshot) More(other *Snapshot) bool {
 	}
 	return snap.CreatedAt > other.CreatedAt
 }
+
+// InDataset returns a copy of the snapshot attributed to another dataset, so
+// that snapshots of different datasets can be compared by name.
+func (snap *Snapshot) InDataset(dataset DatasetName) *Snapshot {
+	out := *snap
+	out.Dataset = dataset
+	return &out
+}
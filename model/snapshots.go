@@ -223,6 +223,17 @@ func (snaps *Snapshots) Has(snap *Snapshot) bool {
 	return exists
 }
 
+// Get returns this collection's copy of the snapshot, or nil.
+func (snaps *Snapshots) Get(snap *Snapshot) *Snapshot {
+	if snaps == nil {
+		return nil
+	}
+	if node, ok := snaps.nodes[snap.ID()]; ok {
+		return node.val
+	}
+	return nil
+}
+
 func (snaps *Snapshots) Len() int {
 	if snaps == nil {
 		return 0
@@ -230,6 +241,28 @@ func (snaps *Snapshots) Len() int {
 	return len(snaps.nodes)
 }
 
+// Used returns the total space the snapshots use on their own. Destroying
+// them all frees at least that much; space that several of them share is
+// freed too, but isn't counted.
+func (snaps *Snapshots) Used() int64 {
+	var total int64
+	for snap := range snaps.All() {
+		total += snap.Used
+	}
+	return total
+}
+
+// Written returns the total space written by the snapshots, each since the
+// previous one. It estimates the size of the incremental streams that send
+// them.
+func (snaps *Snapshots) Written() int64 {
+	var total int64
+	for snap := range snaps.All() {
+		total += snap.Written
+	}
+	return total
+}
+
 // Oldest returns the oldest Snapshot.
 // It returns nil if there are no snapshots.
 func (snaps *Snapshots) Oldest() *Snapshot {
@@ -254,17 +287,42 @@ func (snaps *Snapshots) Newest() *Snapshot {
 	return snaps.tail.val
 }
 
-func (snapshots *Snapshots) MatchingPolicy(policy map[string]int) *Snapshots {
+func (snapshots *Snapshots) MatchingPolicy(policy Policy) *Snapshots {
+	matches, _ := snapshots.ExplainPolicy(policy)
+	return matches
+}
+
+// ExplainPolicy is MatchingPolicy, also returning, for each snapshot by ID,
+// the quota slots it fills, like "daily #3 of 7", or why it fills none.
+func (snapshots *Snapshots) ExplainPolicy(policy Policy) (*Snapshots, map[string]string) {
 	matches := NewSnapshots()
+	why := map[string]string{}
 	accum := map[string]int{}
 	for snapshot := range snapshots.AllDesc() {
-		typ := snapshot.Type()
-		if target, hasPolicy := policy[typ]; hasPolicy && accum[typ] < target {
-			accum[typ]++
-			matches.Add(snapshot)
+		var slots, full []string
+		for _, typ := range policy.Quotas(snapshot.Type()) {
+			target := policy.Counts[typ]
+			if target == 0 {
+				continue
+			}
+			if accum[typ] < target {
+				accum[typ]++
+				matches.Add(snapshot)
+				slots = append(slots, fmt.Sprintf("%s #%d of %d", typ, accum[typ], target))
+			} else {
+				full = append(full, fmt.Sprintf("%s (%d)", typ, target))
+			}
+		}
+		switch {
+		case len(slots) > 0:
+			why[snapshot.ID()] = strings.Join(slots, ", ")
+		case len(full) > 0:
+			why[snapshot.ID()] = fmt.Sprintf("beyond the %s quota", strings.Join(full, ", "))
+		default:
+			why[snapshot.ID()] = fmt.Sprintf("policy keeps no %s snapshots", snapshot.Type())
 		}
 	}
-	return matches
+	return matches, why
 }
 
 func (snaps *Snapshots) Union(other *Snapshots) *Snapshots {
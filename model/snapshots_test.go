@@ -226,3 +226,32 @@ func TestSnapshots_GroupByAdjacency(t *testing.T) {
 		t.Errorf("Expected second group's oldest to be snap4, but got %v", groups[1].Oldest())
 	}
 }
+
+func TestSnapshots_MatchingPolicy_Tiers(t *testing.T) {
+	yearly := &Snapshot{Name: "yearly-2024", CreatedAt: 4}
+	snaps := NewSnapshots(
+		&Snapshot{Name: "daily-1", CreatedAt: 1},
+		&Snapshot{Name: "daily-2", CreatedAt: 2},
+		&Snapshot{Name: "daily-3", CreatedAt: 3},
+		yearly,
+	)
+	counts := map[string]int{"daily": 2, "yearly": 1}
+
+	// Without tiers, the yearly fills only the yearly quota.
+	matches := snaps.MatchingPolicy(Policy{Counts: counts})
+	if matches.Len() != 3 || !matches.Has(&Snapshot{Name: "daily-2"}) {
+		t.Errorf("Expected yearly, daily-3, and daily-2. Got: %s", matches)
+	}
+
+	// With tiers, it also fills one of the daily slots.
+	matches = snaps.MatchingPolicy(Policy{Counts: counts, Tiers: []string{"yearly", "monthly", "daily"}})
+	if matches.Len() != 2 || !matches.Has(yearly) || !matches.Has(&Snapshot{Name: "daily-3"}) {
+		t.Errorf("Expected yearly and daily-3. Got: %s", matches)
+	}
+
+	// A yearly snapshot fills lower tiers even without a yearly quota.
+	matches = snaps.MatchingPolicy(Policy{Counts: map[string]int{"daily": 1}, Tiers: []string{"yearly", "daily"}})
+	if matches.Len() != 1 || !matches.Has(yearly) {
+		t.Errorf("Expected only yearly. Got: %s", matches)
+	}
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// A Target tracks a removable remote pool, which is only present while its
+// drive is attached. Several drives may be rotated under the same pool name;
+// each is tracked separately by GUID, so that local retention keeps a base
+// for every drive's next incremental transfer while it's away.
+type Target struct {
+	Pool     string // Configured pool name or GUID
+	Present  bool
+	Imported bool   // Whether backupd imported the pool, and should export it
+	Current  string // GUID of the attached drive, or the last one seen
+	Drives   map[string]*TargetDrive
+}
+
+// A TargetDrive is one pool that has been seen as the target.
+type TargetDrive struct {
+	GUID       string
+	Name       string
+	LastSeen   time.Time
+	LastSynced time.Time // Zero until a full cycle succeeds
+
+	// Inventory is the drive's remote snapshots as of its last sync, kept
+	// while it's away.
+	Inventory map[DatasetName][]*Snapshot
+}
+
+func NewTarget(pool string) *Target {
+	return &Target{
+		Pool:   pool,
+		Drives: map[string]*TargetDrive{},
+	}
+}
+
+func (t *Target) Clone() *Target {
+	if t == nil {
+		return nil
+	}
+	out := *t
+	out.Drives = make(map[string]*TargetDrive, len(t.Drives))
+	for guid, drive := range t.Drives {
+		d := *drive
+		d.Inventory = maps.Clone(drive.Inventory)
+		out.Drives[guid] = &d
+	}
+	return &out
+}
+
+// CurrentDrive returns the attached drive, or the one last seen.
+func (t *Target) CurrentDrive() *TargetDrive {
+	if t == nil {
+		return nil
+	}
+	return t.Drives[t.Current]
+}
+
+// ListDrives returns every drive seen, most recently seen first.
+func (t *Target) ListDrives() []*TargetDrive {
+	if t == nil {
+		return nil
+	}
+	drives := slices.Collect(maps.Values(t.Drives))
+	slices.SortFunc(drives, func(a, b *TargetDrive) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	return drives
+}
+
+// Protected returns the local snapshots which must be kept for drives that
+// aren't attached: the newest snapshot each of them had of the dataset, so
+// that it can be sent incrementally when the drive comes back.
+func (t *Target) Protected(dataset DatasetName) []*Snapshot {
+	if t == nil {
+		return nil
+	}
+	var out []*Snapshot
+	for guid, drive := range t.Drives {
+		if t.Present && guid == t.Current {
+			continue
+		}
+		if newest := NewSnapshots(drive.Inventory[dataset]...).Newest(); newest != nil {
+			out = append(out, newest)
+		}
+	}
+	return out
+}
+
+func (d *TargetDrive) LastSeenString() string {
+	if d == nil || d.LastSeen.IsZero() {
+		return "never"
+	}
+	return humanize.Time(d.LastSeen)
+}
+
+func (d *TargetDrive) LastSyncedString() string {
+	if d == nil || d.LastSynced.IsZero() {
+		return "never"
+	}
+	return humanize.Time(d.LastSynced)
+}
+
+// SetTarget replaces the model's target.
+func SetTarget(target *Target) func(*Model) *Model {
+	return func(old *Model) *Model {
+		out := old.Clone()
+		out.Target = target
+		return out
+	}
+}
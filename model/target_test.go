@@ -0,0 +1,30 @@
+package model
+
+import "testing"
+
+func TestTargetProtected(t *testing.T) {
+	target := NewTarget("usb")
+	target.Drives["1"] = &TargetDrive{GUID: "1", Inventory: map[DatasetName][]*Snapshot{
+		"/home": {{Name: "daily-1", CreatedAt: 1}, {Name: "daily-2", CreatedAt: 2}},
+	}}
+	target.Drives["2"] = &TargetDrive{GUID: "2", Inventory: map[DatasetName][]*Snapshot{
+		"/home": {{Name: "daily-1", CreatedAt: 1}, {Name: "daily-3", CreatedAt: 3}},
+	}}
+
+	// With drive 1 attached, only drive 2's base is protected.
+	target.Present = true
+	target.Current = "1"
+	protected := target.Protected("/home")
+	if len(protected) != 1 || protected[0].Name != "daily-3" {
+		t.Fatalf("expected daily-3 to be protected, got %v", protected)
+	}
+
+	// With no drive attached, both bases are.
+	target.Present = false
+	if got := len(target.Protected("/home")); got != 2 {
+		t.Fatalf("expected 2 protected snapshots, got %d", got)
+	}
+	if got := len(target.Protected("/other")); got != 0 {
+		t.Fatalf("expected no protected snapshots, got %d", got)
+	}
+}
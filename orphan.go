@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	"monks.co/backupd/config"
+	"monks.co/backupd/model"
+)
+
+// orphanPolicyFor returns the remote retention policy for datasets set
+// aside by a reseed, and whether one is configured.
+func orphanPolicyFor(conf *config.Config) (model.Policy, bool) {
+	if len(conf.Remote.OrphanPolicy) == 0 {
+		return model.Policy{}, false
+	}
+	return remotePolicyFor(conf, conf.Remote.OrphanPolicy), true
+}
+
+// reseedPlan proposes setting aside the remote copy of a dataset which
+// shares no snapshots with local, and sending local from scratch. Reseeding
+// sets the remote aside rather than deleting it, and is held for approval
+// anyway.
+func reseedPlan(conf *config.Config, dataset model.DatasetName, current *model.SnapshotInventory, localPolicy model.Policy, now time.Time) (*model.SnapshotInventory, *model.Plan, error) {
+	aside := model.OrphanedName(dataset, current.Remote.Newest())
+	return model.CalculateReseedPlan(dataset, current, aside, localPolicy, remotePolicyFor(conf, conf.Remote.Policy), now)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/a-h/templ"
+	"monks.co/backupd/model"
+)
+
+// Pin pins or unpins a snapshot on each side that has it, and wakes the
+// sync loop to replan. Pinned snapshots are exempt from retention.
+func (b *Backupd) Pin(ctx context.Context, dataset model.DatasetName, snapshot string, pinned bool) error {
+	ds := b.state.Deref().GetDataset(dataset)
+	if ds == nil || ds.Current == nil {
+		return fmt.Errorf("no such dataset '%s'", dataset)
+	}
+
+	snap := &model.Snapshot{Dataset: dataset, Name: snapshot}
+	onLocal, onRemote := ds.Current.Local.Has(snap), ds.Current.Remote.Has(snap)
+	if !onLocal && !onRemote {
+		return fmt.Errorf("dataset '%s' has no snapshot '%s'", dataset, snapshot)
+	}
+	if onLocal {
+		if err := b.env.Local.SetPinned(ds.Logs, dataset, snapshot, pinned); err != nil {
+			return err
+		}
+	}
+	if onRemote {
+		if err := b.env.Remote.SetPinned(ds.Logs, dataset, snapshot, pinned); err != nil {
+			return err
+		}
+	}
+
+	if err := b.refreshDataset(ctx, ds.Logs, dataset); err != nil {
+		return fmt.Errorf("refreshing '%s': %w", dataset, err)
+	}
+
+	verb := "unpinned"
+	if pinned {
+		verb = "pinned"
+	}
+	b.globalLogs.Printf("%s %s@%s", verb, dataset, snapshot)
+	ds.Logs.Printf("%s %s", verb, snapshot)
+	b.wake()
+
+	return nil
+}
+
+// pinURL is where the UI posts pinning or unpinning a snapshot.
+func pinURL(snap *model.Snapshot, pinned bool) templ.SafeURL {
+	query := url.Values{}
+	query.Set("dataset", snap.Dataset.String())
+	query.Set("snapshot", snap.Name)
+	if !pinned {
+		query.Set("pin", "off")
+	}
+	return templ.SafeURL("/pin?" + query.Encode())
+}
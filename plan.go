@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"monks.co/backupd/config"
+	"monks.co/backupd/model"
+)
+
+// calculatePlan computes the target inventory for a dataset, and the plan to
+// reach it. If orphan recovery is enabled, a dataset whose remote copy
+// shares no snapshots with local gets a reseed plan, held for approval.
+func (b *Backupd) calculatePlan(dataset model.DatasetName, current *model.SnapshotInventory) (*model.SnapshotInventory, *model.Plan, error) {
+	target, plan, err := b.calculatePlanWith(b.config, dataset, current)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.Approved = b.isApproved(dataset, plan)
+	return target, plan, nil
+}
+
+// calculatePlanWith is calculatePlan with the retention settings of the
+// given config, which may differ from the running one.
+func (b *Backupd) calculatePlanWith(conf *config.Config, dataset model.DatasetName, current *model.SnapshotInventory) (*model.SnapshotInventory, *model.Plan, error) {
+	localPolicy := localPolicyFor(conf)
+	if conf.Local.Replica {
+		localPolicy = replicaPolicy(current)
+	}
+
+	remotePolicy := remotePolicyFor(conf, conf.Remote.Policy)
+	if dataset.IsOrphaned() {
+		policy, ok := orphanPolicyFor(conf)
+		if !ok {
+			// Without a policy for them, set-aside datasets are kept whole.
+			return current.Clone(), model.PlanFromOperations(nil), nil
+		}
+		remotePolicy = policy
+	}
+
+	calculateTarget := model.CalculateTargetInventory
+	if conf.Remote.Retention == "archive" {
+		calculateTarget = model.CalculateArchiveTargetInventory
+	}
+	now := time.Now()
+	var heldBack string
+	targetFor := func(current *model.SnapshotInventory) *model.SnapshotInventory {
+		target := calculateTarget(current, localPolicy, remotePolicy, now)
+		b.keepAwayDriveBases(dataset, current, target)
+		heldBack = b.holdBackForSeed(dataset, current, target)
+		return target
+	}
+	target := targetFor(current)
+
+	plan, err := model.CalculateTransitionPlan(current, target)
+	if errors.Is(err, model.ErrNoSharedSnapshot) && conf.Remote.OrphanRecovery == "reseed" {
+		target, plan, err = reseedPlan(conf, dataset, current, localPolicy, now)
+	} else if err == nil {
+		target, plan, err = holdExcessiveDeletions(conf, plan, current, target, targetFor)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.HeldBack = heldBack
+	return target, plan, nil
+}
+
+// localPolicyFor returns the local retention policy.
+func localPolicyFor(conf *config.Config) model.Policy {
+	return model.Policy{
+		Counts: conf.Local.Policy,
+		Tiers:  conf.Tiers,
+		MinAge: time.Duration(conf.Local.MinAge),
+		MaxAge: time.Duration(conf.Local.MaxAge),
+	}
+}
+
+// remotePolicyFor returns the remote retention policy with the given counts.
+func remotePolicyFor(conf *config.Config, counts map[string]int) model.Policy {
+	return model.Policy{
+		Counts: counts,
+		Tiers:  conf.Tiers,
+		MinAge: time.Duration(conf.Remote.MinAge),
+		MaxAge: time.Duration(conf.Remote.MaxAge),
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"monks.co/backupd/config"
+	"monks.co/backupd/model"
+)
+
+// policyDiffReport is the JSON form of what a proposed config would change,
+// as served at /api/v1/policy/diff.
+type policyDiffReport struct {
+	Datasets  []datasetPolicyDiff `json:"datasets"`
+	Unchanged int                 `json:"unchanged"`
+}
+
+type datasetPolicyDiff struct {
+	Dataset             string         `json:"dataset"`
+	NewlyDeleted        locationCounts `json:"newly_deleted"`
+	NoLongerDeleted     locationCounts `json:"no_longer_deleted"`
+	NewlyTransferred    snapshotCount  `json:"newly_transferred"`
+	NoLongerTransferred snapshotCount  `json:"no_longer_transferred"`
+	CurrentSteps        int            `json:"current_steps"`
+	ProposedSteps       int            `json:"proposed_steps"`
+
+	// NeedsApproval is set if the proposed plan would be held.
+	NeedsApproval string `json:"needs_approval,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type locationCounts struct {
+	Local  snapshotCount `json:"local"`
+	Remote snapshotCount `json:"remote"`
+}
+
+// snapshotCount counts some snapshots. Bytes is an estimate: for deleted
+// snapshots, the space they use on their own, which is at least what
+// deleting them frees; for transferred ones, the space each wrote since the
+// previous snapshot, which estimates what sending them costs.
+type snapshotCount struct {
+	Count     int      `json:"count"`
+	Bytes     int64    `json:"bytes"`
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+func countSnapshots(snaps *model.Snapshots, bytes int64) snapshotCount {
+	count := snapshotCount{Count: snaps.Len(), Bytes: bytes}
+	for snap := range snaps.All() {
+		count.Snapshots = append(count.Snapshots, snap.Name)
+	}
+	return count
+}
+
+func countDeleted(snaps *model.Snapshots) snapshotCount {
+	return countSnapshots(snaps, snaps.Used())
+}
+
+func countTransferred(snaps *model.Snapshots) snapshotCount {
+	return countSnapshots(snaps, snaps.Written())
+}
+
+// PolicyDiff compares the plans for every dataset under the running config
+// with those under a proposed one. Nothing is executed.
+func (b *Backupd) PolicyDiff(proposed *config.Config) *policyDiffReport {
+	report := &policyDiffReport{Datasets: []datasetPolicyDiff{}}
+	state := b.state.Deref()
+	for _, name := range state.ListDatasets() {
+		ds := state.GetDataset(name)
+		if ds == nil || ds.Current == nil {
+			continue
+		}
+
+		entry := datasetPolicyDiff{Dataset: name.String()}
+		before, beforePlan, err := b.calculatePlanWith(b.config, name, ds.Current)
+		if err != nil {
+			entry.Error = fmt.Sprintf("current config: %v", err)
+			report.Datasets = append(report.Datasets, entry)
+			continue
+		}
+		after, afterPlan, err := b.calculatePlanWith(proposed, name, ds.Current)
+		if err != nil {
+			entry.Error = fmt.Sprintf("proposed config: %v", err)
+			report.Datasets = append(report.Datasets, entry)
+			continue
+		}
+
+		diff := model.DiffGoals(ds.Current, before, after)
+		if diff.Empty() && beforePlan.NeedsApproval == afterPlan.NeedsApproval {
+			report.Unchanged++
+			continue
+		}
+		entry.NewlyDeleted = locationCounts{countDeleted(diff.NewlyDeleted.Local), countDeleted(diff.NewlyDeleted.Remote)}
+		entry.NoLongerDeleted = locationCounts{countDeleted(diff.NoLongerDeleted.Local), countDeleted(diff.NoLongerDeleted.Remote)}
+		entry.NewlyTransferred = countTransferred(diff.NewlyTransferred)
+		entry.NoLongerTransferred = countTransferred(diff.NoLongerTransferred)
+		entry.CurrentSteps = len(beforePlan.Steps)
+		entry.ProposedSteps = len(afterPlan.Steps)
+		entry.NeedsApproval = afterPlan.NeedsApproval
+		report.Datasets = append(report.Datasets, entry)
+	}
+	return report
+}
+
+// maxProposedConfig is the largest config accepted by /api/v1/policy/diff.
+const maxProposedConfig = 1 << 20
+
+func (b *Backupd) servePolicyDiff(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxProposedConfig))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Proposed config is too large", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proposed, err := config.Read(bytes.NewReader(body), "proposed config")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.PolicyDiff(proposed)); err != nil {
+		b.globalLogs.Printf("writing policy diff: %v", err)
+	}
+}
+
+// RequestPolicyDiff sends the config at path to the running daemon, and
+// prints what would change if it were deployed.
+func (b *Backupd) RequestPolicyDiff(ctx context.Context, path string) error {
+	proposed, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading proposed config: %w", err)
+	}
+
+	resp, err := b.callDaemon(ctx, 5*time.Minute, "POST", apiPrefix+"policy/diff", bytes.NewReader(proposed))
+	if err != nil {
+		return fmt.Errorf("calling policy diff endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("policy diff endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var report policyDiffReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	fmt.Print(report.String())
+	return nil
+}
+
+// String renders the report for the terminal.
+func (report *policyDiffReport) String() string {
+	var out strings.Builder
+	for _, ds := range report.Datasets {
+		fmt.Fprintln(&out, ds.Dataset)
+		if ds.Error != "" {
+			fmt.Fprintf(&out, "  error: %s\n", ds.Error)
+			continue
+		}
+		writeCount(&out, "local: newly deleted", "at least %s freed", ds.NewlyDeleted.Local)
+		writeCount(&out, "local: no longer deleted", "at least %s kept", ds.NoLongerDeleted.Local)
+		writeCount(&out, "remote: newly deleted", "at least %s freed", ds.NewlyDeleted.Remote)
+		writeCount(&out, "remote: no longer deleted", "at least %s kept", ds.NoLongerDeleted.Remote)
+		writeCount(&out, "newly transferred", "about %s sent", ds.NewlyTransferred)
+		writeCount(&out, "no longer transferred", "about %s not sent", ds.NoLongerTransferred)
+		fmt.Fprintf(&out, "  plan: %d steps, was %d\n", ds.ProposedSteps, ds.CurrentSteps)
+		if ds.NeedsApproval != "" {
+			fmt.Fprintf(&out, "  would need approval: %s\n", ds.NeedsApproval)
+		}
+	}
+	if len(report.Datasets) == 0 {
+		fmt.Fprintln(&out, "no changes")
+	}
+	if report.Unchanged > 0 {
+		fmt.Fprintf(&out, "%d datasets unchanged\n", report.Unchanged)
+	}
+	return out.String()
+}
+
+// writeCount writes the count, with its bytes formatted by estimate.
+func writeCount(out io.Writer, label, estimate string, count snapshotCount) {
+	if count.Count == 0 {
+		return
+	}
+	fmt.Fprintf(out, "  %s: %d (%s)\n", label, count.Count, fmt.Sprintf(estimate, humanize.Bytes(uint64(count.Bytes))))
+	for _, name := range count.Snapshots {
+		fmt.Fprintf(out, "    - %s\n", name)
+	}
+}
+
+// parsePolicyArgs parses `diff --config <path>`, returning the path.
+func parsePolicyArgs(args []string) (string, error) {
+	const usage = "usage: backupd policy diff --config <path>"
+	if len(args) == 0 || args[0] != "diff" {
+		return "", errors.New(usage)
+	}
+
+	var path string
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--config" || arg == "-config":
+			if i+1 >= len(args) {
+				return "", errors.New(usage)
+			}
+			i++
+			path = args[i]
+		case strings.HasPrefix(arg, "--config="):
+			path = strings.TrimPrefix(arg, "--config=")
+		default:
+			return "", fmt.Errorf("unexpected argument %s\n%s", arg, usage)
+		}
+	}
+	if path == "" {
+		return "", errors.New(usage)
+	}
+	return path, nil
+}
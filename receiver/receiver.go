@@ -0,0 +1,327 @@
+// Package receiver streams `zfs send` output to a remote `zfs receive` over
+// a direct TCP connection, authenticated with mutual TLS and optionally a
+// pre-shared key, for links where SSH's encryption is the bottleneck.
+// Control-plane commands like list and destroy still go over SSH.
+//
+// The protocol is line-based. The server greets the client with a nonce;
+// the client replies with a JSON request holding the `zfs receive`
+// arguments and, if a pre-shared key is in use, an HMAC of the nonce and
+// arguments; then the client sends the stream and half-closes the
+// connection. Once the receive finishes, the server sends back its output,
+// followed by a final "ok" or "error <message>" line.
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"monks.co/backupd/guard"
+)
+
+const (
+	greeting = "backupd-receive 1"
+
+	// handshakeTimeout bounds how long a client may take to send its
+	// request, so that idle connections don't pile up.
+	handshakeTimeout = 30 * time.Second
+)
+
+type request struct {
+	Args []string
+	MAC  string `json:",omitempty"`
+}
+
+// mac authenticates a request for the given nonce.
+func mac(psk []byte, nonce string, args []string) string {
+	h := hmac.New(sha256.New, psk)
+	fmt.Fprintf(h, "%s\n%s", nonce, strings.Join(args, " "))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// A Server accepts streams and receives them with Receive.
+type Server struct {
+	// Guard confines receives to the remote root.
+	Guard *guard.Guard
+
+	// TLS should require and verify client certificates. The HMAC
+	// covers only the request, not the stream, so TLS is required even
+	// with a pre-shared key.
+	TLS *tls.Config
+
+	// PSK, if set, must also have been used to authenticate each
+	// request.
+	PSK []byte
+
+	// Receive runs `zfs receive` with the given arguments; see
+	// env.Receive.
+	Receive func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error
+
+	Logf func(format string, args ...any)
+}
+
+// Serve accepts connections on ln until the context is canceled.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	if s.TLS == nil {
+		return errors.New("refusing to serve without TLS")
+	}
+	ln = tls.NewListener(ln, s.TLS)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	nonceBytes := make([]byte, 16)
+	rand.Read(nonceBytes)
+	nonce := hex.EncodeToString(nonceBytes)
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if _, err := fmt.Fprintf(conn, "%s %s\n", greeting, nonce); err != nil {
+		s.Logf("[%s] writing greeting: %s", remote, err)
+		return
+	}
+
+	br := bufio.NewReader(conn)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		s.Logf("[%s] reading request: %s", remote, err)
+		return
+	}
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.refuse(conn, br, remote, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+	if s.PSK != nil && !hmac.Equal([]byte(req.MAC), []byte(mac(s.PSK, nonce, req.Args))) {
+		s.refuse(conn, br, remote, errors.New("authentication failed"))
+		return
+	}
+	if len(req.Args) == 0 || req.Args[0] != "-s" {
+		s.refuse(conn, br, remote, fmt.Errorf("not a resumable receive: %v", req.Args))
+		return
+	}
+	if err := s.Guard.CheckArgs(append([]string{"zfs", "receive"}, req.Args...)); err != nil {
+		s.refuse(conn, br, remote, err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.Logf("[%s] receiving: zfs receive %s", remote, strings.Join(req.Args, " "))
+	var out bytes.Buffer
+	if err := s.Receive(ctx, br, &out, &out, req.Args...); err != nil {
+		s.Logf("[%s] receive failed: %s", remote, err)
+		respond(conn, br, out.Bytes(), "error "+oneLine(err.Error()))
+		return
+	}
+	s.Logf("[%s] receive done", remote)
+	respond(conn, br, out.Bytes(), "ok")
+}
+
+func (s *Server) refuse(conn net.Conn, br *bufio.Reader, remote net.Addr, err error) {
+	s.Logf("[%s] refused: %s", remote, err)
+	respond(conn, br, nil, "error "+oneLine(err.Error()))
+}
+
+// respond sends the receive's output and final status, then waits for the
+// client to hang up. If the receive failed partway, the client may still be
+// sending; closing on it would reset the connection, losing the response.
+func respond(conn net.Conn, br *bufio.Reader, output []byte, status string) {
+	if len(output) > 0 && !bytes.HasSuffix(output, []byte("\n")) {
+		output = append(output, '\n')
+	}
+	conn.Write(output)
+	fmt.Fprintln(conn, status)
+
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	io.Copy(io.Discard, br)
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// A Client sends streams to a Server.
+type Client struct {
+	Addr string
+	TLS  *tls.Config
+	PSK  []byte
+}
+
+// Receive sends stdin to the server, to be received with the given `zfs
+// receive` arguments, and copies the server's output to stdout.
+func (c *Client) Receive(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	if c.TLS == nil {
+		return errors.New("refusing to send without TLS")
+	}
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: handshakeTimeout}, Config: c.TLS}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", c.Addr, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading greeting: %w", err)
+	}
+	nonce, ok := strings.CutPrefix(strings.TrimSpace(line), greeting+" ")
+	if !ok {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	req := request{Args: args}
+	if c.PSK != nil {
+		req.MAC = mac(c.PSK, nonce, args)
+	}
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(bs, '\n')); err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+
+	// Send the stream while waiting for the response, which only comes
+	// once the server is done, or if it gives up early.
+	sendErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, stdin)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		sendErr <- err
+	}()
+
+	response, err := io.ReadAll(br)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	output, status := splitStatus(response)
+	stdout.Write(output)
+	switch {
+	case status == "ok":
+		return <-sendErr
+	case strings.HasPrefix(status, "error "):
+		return fmt.Errorf("remote: %s", strings.TrimPrefix(status, "error "))
+	default:
+		return fmt.Errorf("connection closed without a response: %w", errors.Join(err, <-sendErr))
+	}
+}
+
+// splitStatus separates the server's output from its final status line.
+func splitStatus(response []byte) ([]byte, string) {
+	response = bytes.TrimRight(response, "\n")
+	i := bytes.LastIndexByte(response, '\n')
+	return response[:i+1], string(response[i+1:])
+}
+
+// ServerTLS returns a TLS config for a server which requires clients to
+// present a certificate signed by the CA in clientCAFile.
+func ServerTLS(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	pool, err := loadPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// ClientTLS returns a TLS config for a client which presents the given
+// certificate and verifies the server against the CA in caFile.
+func ClientTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	pool, err := loadPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	bs, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, fmt.Errorf("no certificates in '%s'", caFile)
+	}
+	return pool, nil
+}
+
+// ReadPSK reads a pre-shared key from a file.
+func ReadPSK(path string) ([]byte, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pre-shared key: %w", err)
+	}
+	psk := bytes.TrimSpace(bs)
+	if len(psk) < 16 {
+		return nil, fmt.Errorf("pre-shared key in '%s' is too short; use at least 16 bytes", path)
+	}
+	return psk, nil
+}
@@ -0,0 +1,196 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"monks.co/backupd/guard"
+)
+
+// certs writes a CA and a server and client certificate signed by it,
+// returning the directory they're in.
+func certs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, kind string, der []byte) {
+		bs := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+		if err := os.WriteFile(filepath.Join(dir, name), bs, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	issue := func(name string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(name+".pem", "CERTIFICATE", der)
+		write(name+".key", "EC PRIVATE KEY", keyDER)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+
+	ca, caKey := issue("ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	issue("server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	issue("client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	return dir
+}
+
+func serve(t *testing.T, dir, psk string, receive func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error) string {
+	t.Helper()
+	tlsConfig, err := ServerTLS(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s := &Server{
+		Guard:   &guard.Guard{Root: "tank/backups"},
+		TLS:     tlsConfig,
+		PSK:     []byte(psk),
+		Receive: receive,
+		Logf:    t.Logf,
+	}
+	go s.Serve(ctx, ln)
+	return ln.Addr().String()
+}
+
+func newClient(t *testing.T, dir, addr, psk string) *Client {
+	t.Helper()
+	tlsConfig, err := ClientTLS(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{Addr: addr, TLS: tlsConfig, PSK: []byte(psk)}
+}
+
+// countingReceive stands in for `zfs receive`, reporting how many bytes it
+// read.
+func countingReceive(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	n, err := io.Copy(io.Discard, stdin)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "received %d bytes into %s", n, args[len(args)-1])
+	return nil
+}
+
+func TestReceive(t *testing.T) {
+	dir := certs(t)
+	addr := serve(t, dir, "0123456789abcdef", countingReceive)
+	client := newClient(t, dir, addr, "0123456789abcdef")
+
+	var out bytes.Buffer
+	stream := strings.NewReader(strings.Repeat("x", 1<<20))
+	if err := client.Receive(context.Background(), stream, &out, "-s", "-F", "tank/backups/home"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "received 1048576 bytes into tank/backups/home\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestReceiveRefused(t *testing.T) {
+	dir := certs(t)
+	addr := serve(t, dir, "0123456789abcdef", countingReceive)
+
+	for name, tc := range map[string]struct {
+		psk  string
+		args []string
+	}{
+		"wrong key":      {"fedcba9876543210", []string{"-s", "tank/backups/home"}},
+		"outside root":   {"0123456789abcdef", []string{"-s", "tank/other"}},
+		"not resumable":  {"0123456789abcdef", []string{"-A", "tank/backups/home"}},
+		"unknown option": {"0123456789abcdef", []string{"-s", "-o", "mountpoint=/", "tank/backups/home"}},
+		"joined options": {"0123456789abcdef", []string{"-s", "-F tank/backups/home"}},
+	} {
+		client := newClient(t, dir, addr, tc.psk)
+		err := client.Receive(context.Background(), strings.NewReader("stream"), io.Discard, tc.args...)
+		if err == nil || !strings.HasPrefix(err.Error(), "remote: ") {
+			t.Errorf("%s: expected the server to refuse, got %v", name, err)
+		}
+	}
+}
+
+func TestReceiveFailure(t *testing.T) {
+	dir := certs(t)
+	addr := serve(t, dir, "0123456789abcdef", func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+		io.CopyN(io.Discard, stdin, 1024)
+		fmt.Fprintln(stderr, "cannot receive: out of space")
+		return errors.New("exit status 1")
+	})
+	client := newClient(t, dir, addr, "0123456789abcdef")
+
+	var out bytes.Buffer
+	stream := strings.NewReader(strings.Repeat("x", 1<<20))
+	err := client.Receive(context.Background(), stream, &out, "-s", "tank/backups/home")
+	if err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Fatalf("expected the receive's error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "out of space") {
+		t.Fatalf("expected the receive's output, got %q", out.String())
+	}
+}
+
+func TestReceiveWithoutClientCertificate(t *testing.T) {
+	dir := certs(t)
+	addr := serve(t, dir, "0123456789abcdef", countingReceive)
+
+	tlsConfig := newClient(t, dir, addr, "").TLS.Clone()
+	tlsConfig.Certificates = nil
+	client := &Client{Addr: addr, TLS: tlsConfig, PSK: []byte("0123456789abcdef")}
+	err := client.Receive(context.Background(), strings.NewReader("stream"), io.Discard, "-s", "tank/backups/home")
+	if err == nil {
+		t.Fatal("expected the server to refuse a client without a certificate")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"monks.co/backupd/config"
+	"monks.co/backupd/env"
+	"monks.co/backupd/guard"
+	"monks.co/backupd/receiver"
+)
+
+// receiveServer runs on the remote, receiving streams sent over TCP by
+// `backupd receive-client`.
+func receiveServer(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("receive-server", flag.ContinueOnError)
+	listen := fs.String("listen", ":9099", "address to listen on")
+	root := fs.String("root", "", "remote root dataset that receives are confined to")
+	cert := fs.String("cert", "", "server TLS certificate")
+	key := fs.String("key", "", "server TLS key")
+	clientCA := fs.String("client-ca", "", "CA which signs client certificates")
+	pskFile := fs.String("psk-file", "", "file holding a pre-shared key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *root == "" || *cert == "" || *key == "" || *clientCA == "" || fs.NArg() != 0 {
+		return errors.New("usage: backupd receive-server -root <remote root> -cert <file> -key <file> -client-ca <file> [-listen <addr>] [-psk-file <file>]")
+	}
+
+	tlsConfig, err := receiver.ServerTLS(*cert, *key, *clientCA)
+	if err != nil {
+		return err
+	}
+	server := &receiver.Server{
+		Guard:   &guard.Guard{Root: strings.TrimSuffix(*root, "/")},
+		TLS:     tlsConfig,
+		Receive: env.Receive,
+		Logf:    log.Printf,
+	}
+	if *pskFile != "" {
+		psk, err := receiver.ReadPSK(*pskFile)
+		if err != nil {
+			return err
+		}
+		server.PSK = psk
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("receiving into '%s' on %s", *root, ln.Addr())
+	return server.Serve(ctx, ln)
+}
+
+// receiveClient sends stdin to the remote's receive-server. It's run by the
+// daemon in place of `ssh remote zfs receive` when the TCP transport is
+// configured.
+func receiveClient(ctx context.Context, conf *config.Config, args []string) error {
+	tlsConfig, err := receiver.ClientTLS(conf.Remote.TLSCert, conf.Remote.TLSKey, conf.Remote.TLSCA)
+	if err != nil {
+		return err
+	}
+	client := &receiver.Client{Addr: conf.Remote.ReceiveAddr, TLS: tlsConfig}
+	if conf.Remote.PSKFile != "" {
+		psk, err := receiver.ReadPSK(conf.Remote.PSKFile)
+		if err != nil {
+			return err
+		}
+		client.PSK = psk
+	}
+
+	if err := client.Receive(ctx, os.Stdin, os.Stdout, args...); err != nil {
+		return fmt.Errorf("receive-client: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"monks.co/backupd/model"
+)
+
+// replicaPolicy keeps all of a replica's snapshots. They're upstream's to
+// delete: upstream keeps whatever it still needs to send incrementally, and
+// deleting snapshots under it would break its own plans.
+func replicaPolicy(current *model.SnapshotInventory) model.Policy {
+	return model.PolicyKeepingAll(current.Local.Union(current.Remote))
+}
+
+// checkReplicaReadonly warns about replica datasets which aren't readonly.
+// Changes made to them would be rolled back by upstream's next `zfs
+// receive -F`, and would be sent onward in the meantime. The warning is
+// shown on the overview until dismissed, and raised again on a later
+// refresh if the dataset still isn't readonly.
+func (b *Backupd) checkReplicaReadonly() {
+	writable, err := b.env.Local.GetWritable(b.globalLogs)
+	if err != nil {
+		b.globalLogs.Printf("checking readonly on replica: %s", err)
+		return
+	}
+	if len(writable) == 0 {
+		return
+	}
+	state := b.state.Deref()
+	var warnings []string
+	for _, name := range writable {
+		warning := fmt.Sprintf("replica dataset '%s' is not readonly; set readonly=on so it matches upstream", name)
+		b.globalLogs.Printf("warning: %s", warning)
+		if ds := state.GetDataset(name); ds != nil {
+			ds.Logs.Printf("warning: replica dataset is not readonly")
+		}
+		warnings = append(warnings, warning)
+	}
+	b.raiseWarnings(warnings...)
+}
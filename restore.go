@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+)
+
+// Restore plans pulling a snapshot of `dataset` back from the remote into
+// the local dataset `to`, and starts executing it in the background. If
+// `snapshot` is empty, the remote's newest snapshot is restored.
+func (b *Backupd) Restore(ctx context.Context, dataset model.DatasetName, snapshot string, to model.DatasetName) (*model.Restore, error) {
+	if b.config.Local.Replica {
+		return nil, fmt.Errorf("local is a replica; restore on the upstream host instead")
+	}
+
+	logs := logger.New(fmt.Sprintf("restore %s", dataset))
+
+	remoteSnapshots, err := b.env.Remote.GetSnapshots(logs, dataset)
+	if err != nil {
+		return nil, fmt.Errorf("getting remote snapshots for '%s': %w", dataset, err)
+	}
+	localSnapshots, err := b.env.Local.GetSnapshots(logs, to)
+	if err != nil && !strings.Contains(err.Error(), "dataset does not exist") {
+		return nil, fmt.Errorf("getting local snapshots for '%s': %w", to, err)
+	}
+
+	// Attribute the destination's snapshots to the source dataset, so that
+	// they can be matched with the remote's by name.
+	current := model.NewSnapshotInventory(model.NewSnapshots(), model.NewSnapshots(remoteSnapshots...))
+	for _, snap := range localSnapshots {
+		current.Local.Add(snap.InDataset(dataset))
+	}
+
+	var snap *model.Snapshot
+	if snapshot == "" {
+		snap = current.Remote.Newest()
+	} else {
+		for candidate := range current.Remote.All() {
+			if candidate.Name == snapshot {
+				snap = candidate
+			}
+		}
+	}
+	if snap == nil {
+		return nil, fmt.Errorf("no snapshot '%s' of '%s' on remote", snapshot, dataset)
+	}
+
+	plan, err := model.CalculateRestorePlan(current, snap, to)
+	if err != nil {
+		return nil, fmt.Errorf("planning restore of '%s': %w", snap, err)
+	}
+	target := current.Clone()
+	target.Local.Add(snap)
+	if err := model.ValidatePlan(ctx, current, target, plan, false); err != nil {
+		return nil, fmt.Errorf("validating restore plan: %w", err)
+	}
+
+	var restore *model.Restore
+	b.state.Swap(func(state *model.Model) *model.Model {
+		restore = &model.Restore{
+			ID:        len(state.Restores) + 1,
+			Dataset:   dataset,
+			Snapshot:  snap.Name,
+			To:        to,
+			Status:    model.StepPending,
+			CreatedAt: time.Now(),
+			Plan:      plan,
+			Logs:      logs,
+		}
+		return model.AddRestore(restore)(state)
+	})
+	b.globalLogs.Printf("queued %s", restore)
+	b.notifyStateChange()
+
+	go b.runRestore(ctx, restore)
+
+	return restore, nil
+}
+
+// updateRestore updates a restore in a thread-safe manner
+func (b *Backupd) updateRestore(id int, update func(*model.Restore)) {
+	b.state.Swap(func(state *model.Model) *model.Model {
+		restore := state.GetRestore(id)
+		if restore == nil {
+			return state
+		}
+		restore = restore.Clone()
+		update(restore)
+		return model.ReplaceRestore(restore)(state)
+	})
+	b.notifyStateChange()
+}
+
+func (b *Backupd) runRestore(ctx context.Context, restore *model.Restore) {
+	b.setSyncing(restore.To, true)
+	defer b.setSyncing(restore.To, false)
+
+	b.updateRestore(restore.ID, func(r *model.Restore) { r.Status = model.StepInProgress })
+	err := b.executeRestore(ctx, restore)
+	b.updateRestore(restore.ID, func(r *model.Restore) {
+		if err != nil {
+			r.Status = model.StepFailed
+			r.Error = err.Error()
+		} else {
+			r.Status = model.StepCompleted
+		}
+	})
+
+	if err != nil {
+		restore.Logs.Printf("restore failed: %s", err)
+		b.globalLogs.Printf("%s failed: %s", restore, err)
+	} else {
+		b.globalLogs.Printf("%s complete", restore)
+	}
+}
+
+func (b *Backupd) executeRestore(ctx context.Context, restore *model.Restore) error {
+	logs := restore.Logs
+
+	// Pick up an earlier, interrupted restore into the same dataset.
+	token, err := b.env.Local.GetResumeToken(logs, restore.To)
+	if err != nil && !strings.Contains(err.Error(), "dataset does not exist") {
+		return fmt.Errorf("getting resume token for '%s': %w", restore.To, err)
+	}
+	if token != "" {
+		if b.dryrun {
+			logs.Printf("[DRYRUN] Would resume restore into '%s' with token '%s'", restore.To, token)
+		} else {
+			logs.Printf("resuming interrupted restore into '%s'", restore.To)
+			if _, err := b.env.ResumeRestore(ctx, logs, restore.To, token, nil); err != nil {
+				return fmt.Errorf("resuming restore into '%s': %w", restore.To, err)
+			}
+			logs.Printf("resume complete")
+
+			// The resumed transfer may have been this same restore.
+			snaps, err := b.env.Local.GetSnapshots(logs, restore.To)
+			if err != nil {
+				return fmt.Errorf("getting local snapshots for '%s': %w", restore.To, err)
+			}
+			for _, snap := range snaps {
+				if snap.Name == restore.Snapshot {
+					logs.Printf("'%s' was restored by the resumed transfer", restore.Snapshot)
+					for i := range restore.Plan.Steps {
+						b.updateRestore(restore.ID, func(r *model.Restore) {
+							r.Plan.Steps[i].Status = model.StepCompleted
+						})
+					}
+					return nil
+				}
+			}
+		}
+	}
+
+	for i, step := range restore.Plan.Steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		updateStep := func(update func(*model.PlanStep)) {
+			b.updateRestore(restore.ID, func(r *model.Restore) {
+				update(r.Plan.Steps[i])
+			})
+		}
+
+		step.Logs.Printf("Applying op '%s'", step.Operation)
+		err := step.TryExecute(updateStep, func() error {
+			if b.dryrun {
+				step.Logs.Printf("-- [DRYRUN] Would update zfs environment with op '%s'", step)
+				return nil
+			}
+
+			report := func(progress model.TransferProgress) {
+				updateStep(func(s *model.PlanStep) { s.Progress = &progress })
+			}
+			checksum, err := b.apply(ctx, step.Logs, step, nil, report)
+			if err != nil {
+				return fmt.Errorf("applying op '%s' to zfs env: %w", step, err)
+			}
+			if checksum != "" {
+				updateStep(func(s *model.PlanStep) { s.Checksum = checksum })
+			}
+			step.Logs.Printf("-- Done.")
+			return nil
+		})
+		if err != nil {
+			step.Logs.Printf("-- Error: %s", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RequestRestore asks the running daemon to start a restore
+func (b *Backupd) RequestRestore(ctx context.Context, dataset, snapshot, to string) error {
+	query := url.Values{}
+	query.Set("dataset", dataset)
+	query.Set("snapshot", snapshot)
+	query.Set("to", to)
+	resp, err := b.callDaemon(ctx, 5*time.Minute, "POST", "/restore?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("calling restore endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("restore endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Printf("%s", strings.TrimSpace(string(body)))
+	return nil
+}
+
+// parseDatasetName converts a dataset name as typed by a user, where the
+// root dataset is spelled "<root>", into a DatasetName.
+func parseDatasetName(s string) model.DatasetName {
+	if s == "<root>" {
+		return ""
+	}
+	return model.DatasetName(s)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+// snapshotRetention is the JSON form of why a dataset's goal keeps or
+// drops a snapshot, as served at /api/v1/retention.
+type snapshotRetention struct {
+	Snapshot  string             `json:"snapshot"`
+	CreatedAt time.Time          `json:"created_at"`
+	Pinned    bool               `json:"pinned"`
+	Local     *locationRetention `json:"local,omitempty"`
+	Remote    *locationRetention `json:"remote,omitempty"`
+}
+
+type locationRetention struct {
+	Present bool   `json:"present"`
+	Keep    bool   `json:"keep"`
+	Reason  string `json:"reason"`
+}
+
+// retentionReport explains the dataset's goal for each of its snapshots,
+// newest first.
+func retentionReport(ds *model.Dataset) []snapshotRetention {
+	report := []snapshotRetention{}
+	if ds.Current == nil {
+		return report
+	}
+	explain := func(location model.Location, snaps *model.Snapshots, snap *model.Snapshot) *locationRetention {
+		reason, ok := ds.Retention(location, snap)
+		if !ok {
+			return nil
+		}
+		return &locationRetention{
+			Present: snaps.Has(snap),
+			Keep:    reason.Keep,
+			Reason:  reason.Why,
+		}
+	}
+	for snap := range ds.Current.Local.Union(ds.Current.Remote).AllDesc() {
+		report = append(report, snapshotRetention{
+			Snapshot:  snap.Name,
+			CreatedAt: snap.Time(),
+			Pinned:    ds.Current.Pinned(snap),
+			Local:     explain(model.Local, ds.Current.Local, snap),
+			Remote:    explain(model.Remote, ds.Current.Remote, snap),
+		})
+	}
+	return report
+}
+
+func (b *Backupd) serveRetention(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	if !query.Has("dataset") {
+		http.Error(w, "Missing dataset parameter", http.StatusBadRequest)
+		return
+	}
+	ds := b.state.Deref().GetDataset(parseDatasetName(query.Get("dataset")))
+	if ds == nil {
+		http.Error(w, "No such dataset", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(retentionReport(ds)); err != nil {
+		b.globalLogs.Printf("writing retention report: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"monks.co/backupd/env"
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+	"monks.co/backupd/seed"
+)
+
+const defaultSeedChunkSize = "4GiB"
+
+// parseSeedExportArgs parses `[-chunk-size <size>] <dataset> <dir>
+// [snapshot]` into dataset, dir, snapshot, and chunk size.
+func parseSeedExportArgs(args []string) ([]string, error) {
+	const usage = "usage: backupd seed export [-chunk-size <size>] <dataset> <dir> [snapshot]"
+
+	fs := flag.NewFlagSet("seed export", flag.ContinueOnError)
+	chunkSize := fs.String("chunk-size", defaultSeedChunkSize, "size of each stream file")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	positional := fs.Args()
+	if len(positional) < 2 || len(positional) > 3 {
+		return nil, fmt.Errorf("%s", usage)
+	}
+	if len(positional) == 2 {
+		positional = append(positional, "")
+	}
+	return append(positional, *chunkSize), nil
+}
+
+// ExportSeed writes a snapshot of a local dataset to dir, for carrying to
+// the remote and loading with `backupd seed import`. If snapshot is empty,
+// the newest local snapshot is exported.
+//
+// The snapshot is pinned while it's exported, and once the export is
+// complete, the seed is recorded as pending, so that until it's imported,
+// the daemon neither deletes the snapshot nor sends the dataset over the
+// network from scratch.
+func (b *Backupd) ExportSeed(ctx context.Context, dataset, dir, snapshot, chunkSize string) error {
+	logs := b.globalLogs
+	ds := parseDatasetName(dataset)
+
+	size, err := humanize.ParseBytes(chunkSize)
+	if err != nil {
+		return fmt.Errorf("parsing chunk size: %w", err)
+	}
+
+	snaps, err := b.env.Local.GetSnapshots(logs, ds)
+	if err != nil {
+		return fmt.Errorf("getting snapshots of '%s': %w", ds, err)
+	}
+	var snap *model.Snapshot
+	for _, s := range snaps {
+		// Snapshots are listed oldest first, so this finds the newest
+		// if no snapshot was given.
+		if snapshot == "" || s.Name == snapshot {
+			snap = s
+		}
+	}
+	if snap == nil {
+		return fmt.Errorf("dataset '%s' has no snapshot '%s'", ds, snapshot)
+	}
+
+	if !snap.Pinned {
+		if err := b.env.Local.SetPinned(logs, ds, snap.Name, true); err != nil {
+			return err
+		}
+	}
+	unpin := func() {
+		if !snap.Pinned {
+			if err := b.env.Local.SetPinned(logs, ds, snap.Name, false); err != nil {
+				logs.Printf("%v", err)
+			}
+		}
+	}
+
+	manifest, err := b.env.ExportSeed(ctx, logs, ds, snap.Name, dir, int64(size))
+	if err != nil {
+		unpin()
+		return fmt.Errorf("exporting seed: %w", err)
+	}
+
+	pending, err := seed.ReadPending(b.seedsPath())
+	if err == nil {
+		pending[ds] = seed.Pending{Snapshot: snap.Name, ExportedAt: manifest.CreatedAt, Pinned: !snap.Pinned}
+		err = seed.WritePending(b.seedsPath(), pending)
+	}
+	if err != nil {
+		unpin()
+		return fmt.Errorf("recording seed: %w", err)
+	}
+
+	fmt.Printf("exported %s@%s to %s: %d files, %s, sha256 %s\n",
+		manifest.Dataset.Path(), manifest.Snapshot, dir,
+		len(manifest.Chunks), humanize.Bytes(uint64(manifest.TotalBytes)), manifest.SHA256)
+	fmt.Printf("import on the remote with: backupd seed import %s %s\n", dir, b.config.Remote.Root)
+	fmt.Printf("until then, %s is pinned, and its initial transfer is held back\n", manifest.Snapshot)
+	fmt.Printf("to send over the network instead: backupd seed cancel %s\n", manifest.Dataset.Path())
+	return nil
+}
+
+// CancelSeed forgets the dataset's pending seed, so that the daemon sends
+// its initial transfer over the network after all, and unpins the
+// snapshot if exporting pinned it.
+func (b *Backupd) CancelSeed(ctx context.Context, dataset string) error {
+	logs := b.globalLogs
+	ds := parseDatasetName(dataset)
+
+	pending, err := seed.ReadPending(b.seedsPath())
+	if err != nil {
+		return err
+	}
+	p, ok := pending[ds]
+	if !ok {
+		return fmt.Errorf("dataset '%s' has no pending seed", ds)
+	}
+	if p.Pinned {
+		if err := b.env.Local.SetPinned(logs, ds, p.Snapshot, false); err != nil {
+			return err
+		}
+	}
+	delete(pending, ds)
+	if err := seed.WritePending(b.seedsPath(), pending); err != nil {
+		return err
+	}
+
+	fmt.Printf("cancelled the seed of %s@%s; its initial transfer will be sent over the network\n", ds.Path(), p.Snapshot)
+	return nil
+}
+
+// seedsPath is where seeds which were exported, but haven't yet arrived on
+// the remote, are recorded.
+func (b *Backupd) seedsPath() string {
+	return filepath.Join(b.config.StateDir, "seeds.json")
+}
+
+// seedOverdueAfter is how long after its export a seed that hasn't arrived
+// on the remote is shown as a warning.
+const seedOverdueAfter = 14 * 24 * time.Hour
+
+// holdBackForSeed holds back the dataset's initial transfer, if it has
+// nothing on the remote yet and a seed of it is on its way there, by leaving
+// the target's remote as it is; only local retention runs meanwhile. It
+// returns why the transfer is held back, or "" if it isn't.
+func (b *Backupd) holdBackForSeed(dataset model.DatasetName, current, target *model.SnapshotInventory) string {
+	if current.Remote.Len() > 0 {
+		return ""
+	}
+	pending, err := seed.ReadPending(b.seedsPath())
+	if err != nil {
+		b.globalLogs.Printf("%v", err)
+		return ""
+	}
+	p, ok := pending[dataset]
+	if !ok {
+		return ""
+	}
+	target.Remote = current.Remote.Clone()
+	return fmt.Sprintf("initial transfer waits for the seed of %s, exported %s, to be imported on the remote; run `backupd seed cancel %s` to send it over the network instead",
+		p.Snapshot, humanize.Time(p.ExportedAt), dataset.Path())
+}
+
+// checkSeed forgets the dataset's pending seed once its snapshot has
+// arrived on the remote, and unpins the snapshot if exporting pinned it.
+func (b *Backupd) checkSeed(ctx context.Context, logs *logger.Logger, dataset model.DatasetName) error {
+	ds := b.state.Deref().GetDataset(dataset)
+	if b.dryrun || ds == nil || ds.Current == nil {
+		return nil
+	}
+	pending, err := seed.ReadPending(b.seedsPath())
+	if err != nil {
+		return err
+	}
+	p, ok := pending[dataset]
+	if !ok {
+		return nil
+	}
+	snap := &model.Snapshot{Dataset: dataset, Name: p.Snapshot}
+	if !ds.Current.Remote.Has(snap) {
+		if ds.Current.Remote.Len() == 0 {
+			logs.Printf("holding back the initial transfer until the seed of %s is imported on the remote", p.Snapshot)
+			if time.Since(p.ExportedAt) > seedOverdueAfter {
+				b.raiseWarnings(fmt.Sprintf("the seed of %s@%s was exported on %s and still hasn't been imported on the remote; import it, or run `backupd seed cancel %s`",
+					dataset.Path(), p.Snapshot, p.ExportedAt.Format(time.DateOnly), dataset.Path()))
+			}
+		}
+		return nil
+	}
+
+	logs.Printf("seed of %s arrived on the remote", p.Snapshot)
+	if p.Pinned && ds.Current.Local.Has(snap) {
+		if err := b.env.Local.SetPinned(logs, dataset, p.Snapshot, false); err != nil {
+			return err
+		}
+	}
+	delete(pending, dataset)
+	if err := seed.WritePending(b.seedsPath(), pending); err != nil {
+		return err
+	}
+	return b.refreshDataset(ctx, logs, dataset)
+}
+
+// importSeed receives the seed in dir into the dataset of the same name
+// under root. It runs on the remote, so it doesn't use the config.
+func importSeed(ctx context.Context, dir, root string) error {
+	logs := logger.New("seed import")
+	manifest, err := env.ImportSeed(ctx, logs, env.NewZFS(strings.TrimSuffix(root, "/"), env.Local), dir)
+	if err != nil {
+		return fmt.Errorf("importing seed: %w", err)
+	}
+
+	fmt.Printf("imported %s@%s into %s\n", manifest.Dataset.Path(), manifest.Snapshot, root)
+	return nil
+}
@@ -0,0 +1,285 @@
+// Package seed reads and writes seed directories: a `zfs send` stream split
+// into fixed-size chunk files, with a manifest of their checksums, for
+// carrying an initial transfer to the remote on removable media.
+package seed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+const manifestName = "manifest.json"
+
+// Manifest describes the contents of a seed directory.
+type Manifest struct {
+	Dataset    model.DatasetName
+	Snapshot   string
+	CreatedAt  time.Time
+	ChunkSize  int64
+	Chunks     []Chunk
+	TotalBytes int64
+	SHA256     string // Of the whole stream
+}
+
+// Chunk is one file of the split stream.
+type Chunk struct {
+	Name   string
+	Bytes  int64
+	SHA256 string
+}
+
+// A Writer splits a stream into chunk files in a directory. Close must be
+// called to write the manifest; a directory without one is incomplete.
+type Writer struct {
+	dir       string
+	manifest  Manifest
+	total     hash.Hash
+	file      *os.File
+	chunkHash hash.Hash
+	chunkLen  int64
+
+	// created is whether Create made dir, so that Abort removes it.
+	created bool
+}
+
+// Create starts a new seed in dir, which must be empty or not exist.
+func Create(dir string, dataset model.DatasetName, snapshot string, chunkSize int64) (*Writer, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	_, err := os.Stat(dir)
+	created := os.IsNotExist(err)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("'%s' is not empty", dir)
+	}
+
+	return &Writer{
+		dir: dir,
+		manifest: Manifest{
+			Dataset:   dataset,
+			Snapshot:  snapshot,
+			CreatedAt: time.Now(),
+			ChunkSize: chunkSize,
+		},
+		total:   sha256.New(),
+		created: created,
+	}, nil
+}
+
+func (w *Writer) Write(bs []byte) (int, error) {
+	written := 0
+	for len(bs) > 0 {
+		if w.file == nil {
+			if err := w.openChunk(); err != nil {
+				return written, err
+			}
+		}
+
+		n := min(int64(len(bs)), w.manifest.ChunkSize-w.chunkLen)
+		if _, err := w.file.Write(bs[:n]); err != nil {
+			return written, err
+		}
+		w.chunkHash.Write(bs[:n])
+		w.total.Write(bs[:n])
+		w.chunkLen += n
+		w.manifest.TotalBytes += n
+		written += int(n)
+		bs = bs[n:]
+
+		if w.chunkLen == w.manifest.ChunkSize {
+			if err := w.closeChunk(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *Writer) openChunk() error {
+	name := fmt.Sprintf("stream.%06d", len(w.manifest.Chunks))
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.chunkHash = sha256.New()
+	w.chunkLen = 0
+	w.manifest.Chunks = append(w.manifest.Chunks, Chunk{Name: name})
+	return nil
+}
+
+func (w *Writer) closeChunk() error {
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	chunk := &w.manifest.Chunks[len(w.manifest.Chunks)-1]
+	chunk.Bytes = w.chunkLen
+	chunk.SHA256 = hex.EncodeToString(w.chunkHash.Sum(nil))
+	w.file = nil
+	return nil
+}
+
+// Close finishes the last chunk and writes the manifest.
+func (w *Writer) Close() (*Manifest, error) {
+	if w.file != nil {
+		if err := w.closeChunk(); err != nil {
+			return nil, err
+		}
+	}
+	w.manifest.SHA256 = hex.EncodeToString(w.total.Sum(nil))
+
+	bs, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, manifestName), bs, 0o600); err != nil {
+		return nil, err
+	}
+	return &w.manifest, nil
+}
+
+// Abort removes what was written so far, and dir itself if Create made
+// it, so that a failed export doesn't leave an incomplete seed behind.
+func (w *Writer) Abort() error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	if w.created {
+		return os.RemoveAll(w.dir)
+	}
+	for _, chunk := range w.manifest.Chunks {
+		if err := os.Remove(filepath.Join(w.dir, chunk.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadManifest reads the manifest of the seed in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	bs, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(bs, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// Verify checks every chunk of the seed in dir against its manifest.
+func Verify(dir string, manifest *Manifest) error {
+	for _, chunk := range manifest.Chunks {
+		f, err := os.Open(filepath.Join(dir, chunk.Name))
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", chunk.Name, err)
+		}
+		if n != chunk.Bytes {
+			return fmt.Errorf("%s: expected %d bytes, found %d", chunk.Name, chunk.Bytes, n)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != chunk.SHA256 {
+			return fmt.Errorf("%s: checksum mismatch: expected %s, got %s", chunk.Name, chunk.SHA256, sum)
+		}
+	}
+	return nil
+}
+
+// Open returns the seed's stream, reassembled from its chunks. The stream
+// fails with an error at the end if its checksum doesn't match the
+// manifest's, so a corrupted seed can't be received silently.
+func Open(dir string, manifest *Manifest) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		total := sha256.New()
+		for _, chunk := range manifest.Chunks {
+			f, err := os.Open(filepath.Join(dir, chunk.Name))
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(io.MultiWriter(pw, total), f)
+			f.Close()
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("reading %s: %w", chunk.Name, err))
+				return
+			}
+		}
+		if sum := hex.EncodeToString(total.Sum(nil)); sum != manifest.SHA256 {
+			pw.CloseWithError(fmt.Errorf("stream checksum mismatch: expected %s, got %s", manifest.SHA256, sum))
+			return
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+// Pending is a seed that was exported, and hasn't yet been seen on the
+// remote.
+type Pending struct {
+	Snapshot   string    `json:"snapshot"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	// Pinned is whether exporting pinned the snapshot, so that it's
+	// unpinned again once the seed arrives.
+	Pinned bool `json:"pinned"`
+}
+
+// ReadPending reads the pending seeds, by dataset, recorded at path. A
+// missing file records none.
+func ReadPending(path string) (map[model.DatasetName]Pending, error) {
+	bs, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[model.DatasetName]Pending{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading pending seeds: %w", err)
+	}
+	pending := map[model.DatasetName]Pending{}
+	if err := json.Unmarshal(bs, &pending); err != nil {
+		return nil, fmt.Errorf("parsing pending seeds: %w", err)
+	}
+	return pending, nil
+}
+
+// WritePending records the pending seeds at path, replacing the file
+// whole so that readers never see it half written.
+func WritePending(path string, pending map[model.DatasetName]Pending) error {
+	bs, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bs, 0o600); err != nil {
+		return fmt.Errorf("writing pending seeds: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing pending seeds: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package seed
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSeed(t *testing.T, dir string, data []byte, chunkSize int64) *Manifest {
+	t.Helper()
+	w, err := Create(dir, "/home", "daily-2024-01-01", chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	writeSeed(t, dir, data, 100)
+
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(manifest.Chunks))
+	}
+	if manifest.TotalBytes != int64(len(data)) {
+		t.Fatalf("expected %d bytes, got %d", len(data), manifest.TotalBytes)
+	}
+	if err := Verify(dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(Open(dir, manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("stream differs from what was written")
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	dir := t.TempDir()
+	manifest := writeSeed(t, dir, bytes.Repeat([]byte("x"), 250), 100)
+
+	path := filepath.Join(dir, manifest.Chunks[1].Name)
+	if err := os.WriteFile(path, bytes.Repeat([]byte("y"), 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Verify(dir, manifest); err == nil {
+		t.Fatal("expected Verify to detect the corrupted chunk")
+	}
+	if _, err := io.ReadAll(Open(dir, manifest)); err == nil {
+		t.Fatal("expected the stream to fail its checksum")
+	}
+}
+
+func TestCreateRequiresEmptyDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "other"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(dir, "/home", "daily", 100); err == nil {
+		t.Fatal("expected an error for a non-empty directory")
+	}
+}
+
+func TestAbort(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "seed")
+	w, err := Create(dir, "/home", "daily", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte("x"), 250)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the directory Create made to be removed, got %v", err)
+	}
+
+	// A directory that was already there is emptied, not removed.
+	dir = t.TempDir()
+	w, err = Create(dir, "/home", "daily", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte("x"), 250)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("expected an empty directory, got %v, %v", entries, err)
+	}
+}
+
+func TestPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds.json")
+	pending, err := ReadPending(path)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending seeds before any are written, got %v, %v", pending, err)
+	}
+
+	pending["/home"] = Pending{Snapshot: "daily-2024-01-01", Pinned: true}
+	if err := WritePending(path, pending); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadPending(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := got["/home"]; p.Snapshot != "daily-2024-01-01" || !p.Pinned || len(got) != 1 {
+		t.Errorf("unexpected pending seeds %v", got)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"monks.co/backupd/config"
+	"monks.co/backupd/model"
+	"monks.co/backupd/seed"
+)
+
+func TestSeedHoldsBackInitialTransfer(t *testing.T) {
+	conf := &config.Config{StateDir: t.TempDir(), Tiers: []string{"daily"}}
+	conf.Local.Policy = map[string]int{"daily": 5}
+	conf.Remote.Policy = map[string]int{"daily": 5}
+	b := New(conf, "", false)
+	b.state.Reset(model.New())
+
+	snap := &model.Snapshot{Dataset: "/home", Name: "daily-1", CreatedAt: time.Now().Add(-time.Hour).Unix()}
+	current := model.NewSnapshotInventory(model.NewSnapshots(snap), model.NewSnapshots())
+
+	pending := map[model.DatasetName]seed.Pending{"/home": {Snapshot: "daily-1", ExportedAt: time.Now()}}
+	if err := seed.WritePending(filepath.Join(conf.StateDir, "seeds.json"), pending); err != nil {
+		t.Fatal(err)
+	}
+	_, plan, err := b.calculatePlan("/home", current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps) != 0 {
+		t.Errorf("expected the initial transfer to be held back, got %v", plan.Steps)
+	}
+	if plan.HeldBack == "" {
+		t.Error("expected the plan to say why the transfer is held back")
+	}
+	if plan.Held() {
+		t.Error("expected the plan not to need approval")
+	}
+
+	// Once the seed is cancelled, the transfer is planned.
+	if err := seed.WritePending(filepath.Join(conf.StateDir, "seeds.json"), nil); err != nil {
+		t.Fatal(err)
+	}
+	_, plan, err = b.calculatePlan("/home", current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps) != 1 || plan.HeldBack != "" {
+		t.Errorf("expected the transfer to be planned, got %v, %q", plan.Steps, plan.HeldBack)
+	}
+}
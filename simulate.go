@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"monks.co/backupd/config"
+	"monks.co/backupd/simulate"
+)
+
+// simulateRetention runs the configured retention policies against a
+// snapshot schedule over a virtual clock, and reports which snapshots each
+// side would hold. It touches neither zfs nor a running daemon.
+func simulateRetention(args []string) error {
+	const usage = "usage: backupd simulate [-config <path>] [-months <n>] [-start <date>] [-cycle <duration>] [-schedule <type>=<cron>]... [-html <file>]"
+
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config whose policies to simulate (default: the installed config)")
+	months := fs.Int("months", 12, "how many months to simulate")
+	start := fs.String("start", "", "date to start at, as YYYY-MM-DD (default: today)")
+	cycle := fs.Duration("cycle", time.Hour, "how often backupd syncs")
+	htmlPath := fs.String("html", "", "also write an HTML timeline to this file")
+	var schedules []simulate.Schedule
+	fs.Func("schedule", "when snapshots of a type are taken, as <type>=<cron expression>; repeatable (default: the README's crontab)", func(s string) error {
+		sched, err := simulate.ParseSchedule(s)
+		if err != nil {
+			return err
+		}
+		schedules = append(schedules, sched)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *months < 1 || *cycle <= 0 {
+		return errors.New(usage)
+	}
+
+	if len(schedules) == 0 {
+		for _, s := range simulate.DefaultSchedules {
+			sched, err := simulate.ParseSchedule(s)
+			if err != nil {
+				return err
+			}
+			schedules = append(schedules, sched)
+		}
+	}
+
+	startAt := time.Now().Truncate(24 * time.Hour)
+	if *start != "" {
+		var err error
+		if startAt, err = time.ParseInLocation(time.DateOnly, *start, time.Local); err != nil {
+			return fmt.Errorf("parsing -start: %w", err)
+		}
+	}
+
+	var conf *config.Config
+	var err error
+	if *configPath == "" {
+		conf, err = config.Load()
+	} else {
+		var f *os.File
+		if f, err = os.Open(*configPath); err != nil {
+			return fmt.Errorf("opening config: %w", err)
+		}
+		defer f.Close()
+		conf, err = config.Read(f, *configPath)
+	}
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	// Goal calculation logs each snapshot it keeps, every cycle.
+	log.SetOutput(io.Discard)
+	result, err := simulate.Run(simulate.Options{
+		Start:        startAt,
+		Duration:     startAt.AddDate(0, *months, 0).Sub(startAt),
+		Cycle:        *cycle,
+		Schedules:    schedules,
+		LocalPolicy:  localPolicyFor(conf),
+		RemotePolicy: remotePolicyFor(conf, conf.Remote.Policy),
+		Archive:      conf.Remote.Retention == "archive",
+	})
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if err := result.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if *htmlPath != "" {
+		f, err := os.Create(*htmlPath)
+		if err != nil {
+			return fmt.Errorf("creating timeline: %w", err)
+		}
+		if err := result.WriteHTML(f); err != nil {
+			f.Close()
+			return fmt.Errorf("writing timeline: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("writing timeline: %w", err)
+		}
+	}
+	return nil
+}
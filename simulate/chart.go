@@ -0,0 +1,85 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+// WriteHTML writes the result as a standalone page with a timeline per
+// side.
+func (result *Result) WriteHTML(w io.Writer) error {
+	return timeline(result).Render(context.Background(), w)
+}
+
+const chartWidth, chartHeight = 900.0, 360.0
+
+// chartPalette colors snapshot types, in schedule order.
+var chartPalette = []string{"#9e9e9e", "#2196f3", "#4caf50", "#ff9800", "#e91e63", "#9c27b0", "#795548"}
+
+// A segment is one snapshot's time on one side, drawn as a horizontal line:
+// x is when it existed, and y is when it was taken.
+type segment struct {
+	X1, X2, Y float64
+	Color     string
+	Title     string
+}
+
+// segments returns the chart for one side.
+func (result *Result) segments(location model.Location) []segment {
+	colors := map[string]string{}
+	for i, typ := range result.Types() {
+		colors[typ] = chartPalette[i%len(chartPalette)]
+	}
+
+	var segments []segment
+	for _, lifetime := range result.Lifetimes {
+		from, to := lifetime.LocalFrom, lifetime.LocalTo
+		if location == model.Remote {
+			from, to = lifetime.RemoteFrom, lifetime.RemoteTo
+		}
+		if from.IsZero() {
+			continue
+		}
+		if to.IsZero() {
+			to = result.End
+		}
+		// Hide snapshots deleted by the cycle that followed them, or the
+		// chart is a solid block of hourlies.
+		if to.Sub(from) <= result.Options.Cycle && !to.Equal(result.End) {
+			continue
+		}
+		segments = append(segments, segment{
+			X1:    result.chartX(from),
+			X2:    result.chartX(to),
+			Y:     result.chartY(lifetime.Snapshot.Time()),
+			Color: colors[lifetime.Snapshot.Type()],
+			Title: fmt.Sprintf("%s: %s to %s", lifetime.Snapshot.Name, from.Format(time.DateTime), to.Format(time.DateTime)),
+		})
+	}
+	return segments
+}
+
+func (result *Result) chartX(t time.Time) float64 {
+	return chartWidth * t.Sub(result.Options.Start).Seconds() / result.End.Sub(result.Options.Start).Seconds()
+}
+
+func (result *Result) chartY(t time.Time) float64 {
+	return chartHeight - result.chartX(t)*chartHeight/chartWidth
+}
+
+// legend pairs each snapshot type with its color.
+func (result *Result) legend() [][2]string {
+	var legend [][2]string
+	for i, typ := range result.Types() {
+		legend = append(legend, [2]string{typ, chartPalette[i%len(chartPalette)]})
+	}
+	return legend
+}
+
+func svgNum(f float64) string {
+	return fmt.Sprintf("%.1f", f)
+}
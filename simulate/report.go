@@ -0,0 +1,89 @@
+package simulate
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+// Types returns the snapshot types in the simulation, in schedule order.
+func (result *Result) Types() []string {
+	var types []string
+	for _, sched := range result.Options.Schedules {
+		if !slices.Contains(types, sched.Type) {
+			types = append(types, sched.Type)
+		}
+	}
+	return types
+}
+
+// WriteText writes the result for the terminal: a line per sampled day with
+// the counts on each side, then the final state and the widest gaps.
+func (result *Result) WriteText(w io.Writer) error {
+	types := result.Types()
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%-10s  %-*s  %s\n", "date", 4*len(types)+2, "local", "remote")
+	fmt.Fprintf(&out, "%-10s  ", "")
+	for range 2 {
+		for _, typ := range types {
+			fmt.Fprintf(&out, "%4.3s", typ)
+		}
+		out.WriteString("  ")
+	}
+	out.WriteString("\n")
+	for _, sample := range result.Samples {
+		fmt.Fprintf(&out, "%-10s  ", sample.At.Format(time.DateOnly))
+		for _, counts := range []map[string]int{sample.Local, sample.Remote} {
+			for _, typ := range types {
+				fmt.Fprintf(&out, "%4d", counts[typ])
+			}
+			out.WriteString("  ")
+		}
+		out.WriteString("\n")
+	}
+
+	fmt.Fprintf(&out, "\nat %s:\n", result.End.Format(time.DateTime))
+	writeSnapshots(&out, "local", result.Final.Local)
+	writeSnapshots(&out, "remote", result.Final.Remote)
+
+	out.WriteString("\nmax gap between retained snapshots:\n")
+	for _, location := range []model.Location{model.Local, model.Remote} {
+		gap := result.MaxGap[location]
+		if gap.From == nil {
+			fmt.Fprintf(&out, "  %s: none\n", strings.ToLower(location.String()))
+			continue
+		}
+		fmt.Fprintf(&out, "  %s: %s, between %s and %s (at %s)\n",
+			strings.ToLower(location.String()), FormatDuration(gap.Duration()),
+			gap.From.Name, gap.To.Name, gap.At.Format(time.DateTime))
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func writeSnapshots(out io.Writer, label string, snaps *model.Snapshots) {
+	fmt.Fprintf(out, "  %s: %d snapshots\n", label, snaps.Len())
+	for snap := range snaps.AllDesc() {
+		fmt.Fprintf(out, "    %s\n", snap.Name)
+	}
+}
+
+// FormatDuration formats a duration in days and hours, which reads better
+// than time.Duration's hours at these scales.
+func FormatDuration(d time.Duration) string {
+	days, hours := int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour)
+	switch {
+	case days == 0:
+		return fmt.Sprintf("%dh", hours)
+	case hours == 0:
+		return fmt.Sprintf("%dd", days)
+	default:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	}
+}
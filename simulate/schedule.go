@@ -0,0 +1,127 @@
+package simulate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule takes snapshots of one type whenever its cron expression
+// matches, as a crontab entry running `backupd snapshot <type>` would.
+type Schedule struct {
+	Type string
+	Cron string
+
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+// DefaultSchedules is the snapshot regime recommended in the README.
+var DefaultSchedules = []string{
+	"hourly=0 * * * *",
+	"daily=0 0 * * *",
+	"weekly=0 0 * * 0",
+	"monthly=0 0 1 * *",
+	"yearly=0 0 1 1 *",
+}
+
+// ParseSchedule parses `<type>=<cron expression>`, like "daily=0 0 * * *".
+// Cron expressions have the usual five fields (minute, hour, day of month,
+// month, day of week), each `*`, or a list of numbers, ranges, and steps.
+func ParseSchedule(s string) (Schedule, error) {
+	typ, expr, ok := strings.Cut(s, "=")
+	typ, expr = strings.TrimSpace(typ), strings.TrimSpace(expr)
+	if !ok || typ == "" || strings.Contains(typ, "-") {
+		return Schedule{}, fmt.Errorf("schedule %q: expected <type>=<cron expression>", s)
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("schedule %q: expected 5 cron fields, got %d", s, len(fields))
+	}
+
+	sched := Schedule{Type: typ, Cron: expr}
+	var err error
+	if sched.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: minute: %w", s, err)
+	}
+	if sched.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: hour: %w", s, err)
+	}
+	if sched.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: day of month: %w", s, err)
+	}
+	if sched.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: month: %w", s, err)
+	}
+	if sched.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: day of week: %w", s, err)
+	}
+	sched.dow[0] = sched.dow[0] || sched.dow[7] // 7 is Sunday too
+	sched.domAny, sched.dowAny = fields[2] == "*", fields[4] == "*"
+	return sched, nil
+}
+
+// Matches reports whether the schedule takes a snapshot at the given minute.
+func (sched Schedule) Matches(t time.Time) bool {
+	if !sched.minute[t.Minute()] || !sched.hour[t.Hour()] || !sched.month[int(t.Month())] {
+		return false
+	}
+	// As in cron, if both days are restricted, either may match.
+	dom, dow := sched.dom[t.Day()], sched.dow[int(t.Weekday())]
+	switch {
+	case sched.domAny && sched.dowAny:
+		return true
+	case sched.domAny:
+		return dow
+	case sched.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (sched Schedule) String() string {
+	return sched.Type + "=" + sched.Cron
+}
+
+// parseCronField returns which values from low to high the field allows,
+// indexed by value.
+func parseCronField(field string, low, high int) ([]bool, error) {
+	allowed := make([]bool, high+1)
+	for part := range strings.SplitSeq(field, ",") {
+		rng, step := part, 1
+		r, s, stepped := strings.Cut(part, "/")
+		if stepped {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step %q", s)
+			}
+			rng, step = r, n
+		}
+
+		lo, hi := low, high
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("invalid value %q", first)
+			}
+			switch {
+			case isRange:
+				if hi, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("invalid value %q", last)
+				}
+			case !stepped:
+				hi = lo
+			}
+		}
+		if lo < low || hi > high || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, low, high)
+		}
+		for v := lo; v <= hi; v += step {
+			allowed[v] = true
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,186 @@
+// Package simulate runs retention policies against a snapshot schedule over
+// a virtual clock, using the same goal and plan calculations as the daemon,
+// so that a policy can be evaluated before it's deployed.
+package simulate
+
+import (
+	"fmt"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+// Options configure a simulation.
+type Options struct {
+	Start    time.Time
+	Duration time.Duration
+
+	// Cycle is how often backupd syncs; an hour, like the daemon.
+	Cycle time.Duration
+
+	Schedules    []Schedule
+	LocalPolicy  model.Policy
+	RemotePolicy model.Policy
+
+	// Archive selects the remote's archive retention mode.
+	Archive bool
+}
+
+// A Lifetime is when a snapshot existed on each side. Zero times mean it
+// never got there, or was still there at the end.
+type Lifetime struct {
+	Snapshot             *model.Snapshot
+	LocalFrom, LocalTo   time.Time
+	RemoteFrom, RemoteTo time.Time
+}
+
+// A Sample counts the snapshots on each side, by type, at one time.
+type Sample struct {
+	At            time.Time
+	Local, Remote map[string]int
+}
+
+// A Gap is the time between two consecutive snapshots on one side.
+type Gap struct {
+	From, To *model.Snapshot
+	At       time.Time
+}
+
+func (gap Gap) Duration() time.Duration {
+	if gap.From == nil {
+		return 0
+	}
+	return gap.To.Time().Sub(gap.From.Time())
+}
+
+// Result is the outcome of a simulation.
+type Result struct {
+	Options   Options
+	End       time.Time
+	Lifetimes []*Lifetime
+	Samples   []Sample
+	Final     *model.SnapshotInventory
+
+	// MaxGap is the widest gap between retained snapshots on each side,
+	// at any point in the simulation.
+	MaxGap map[model.Location]Gap
+}
+
+// Run runs a simulation. Snapshots are taken at each minute a schedule
+// matches, and every Cycle, the goal and plan are calculated and the plan's
+// operations applied. The state is sampled once a day.
+func Run(opts Options) (*Result, error) {
+	if opts.Cycle <= 0 {
+		opts.Cycle = time.Hour
+	}
+	start := opts.Start.Truncate(time.Minute)
+	end := start.Add(opts.Duration)
+
+	calculateTarget := model.CalculateTargetInventory
+	if opts.Archive {
+		calculateTarget = model.CalculateArchiveTargetInventory
+	}
+
+	result := &Result{
+		Options: opts,
+		End:     end,
+		MaxGap:  map[model.Location]Gap{},
+	}
+	lifetimes := map[string]*Lifetime{}
+	inv := model.NewSnapshotInventory(model.NewSnapshots(), model.NewSnapshots())
+	nextCycle, nextSample := start.Add(opts.Cycle), start
+
+	for now := start; !now.After(end); now = now.Add(time.Minute) {
+		// Snapshots due in the same minute are taken a second apart, as
+		// cron jobs would be, since zfs can't send between snapshots with
+		// the same creation time.
+		var taken int
+		for _, sched := range opts.Schedules {
+			if !sched.Matches(now) {
+				continue
+			}
+			at := now.Add(time.Duration(taken) * time.Second)
+			taken++
+			snap := &model.Snapshot{
+				Name:      fmt.Sprintf("%s-%s", sched.Type, at.Format("2006-01-02-15:04:05")),
+				CreatedAt: at.Unix(),
+			}
+			inv.Local.Add(snap)
+			lifetime := &Lifetime{Snapshot: snap, LocalFrom: now}
+			lifetimes[snap.ID()] = lifetime
+			result.Lifetimes = append(result.Lifetimes, lifetime)
+		}
+
+		if now.Before(nextCycle) {
+			continue
+		}
+		nextCycle = nextCycle.Add(opts.Cycle)
+
+		target := calculateTarget(inv, opts.LocalPolicy, opts.RemotePolicy, now)
+		plan, err := model.CalculateTransitionPlan(inv, target)
+		if err != nil {
+			return nil, fmt.Errorf("planning at %s: %w", now.Format(time.DateTime), err)
+		}
+		next := inv
+		for _, step := range plan.Steps {
+			if next, err = step.Apply(next); err != nil {
+				return nil, fmt.Errorf("applying %s at %s: %w", step, now.Format(time.DateTime), err)
+			}
+		}
+
+		for snap := range inv.Local.Difference(next.Local).All() {
+			lifetimes[snap.ID()].LocalTo = now
+		}
+		for snap := range next.Remote.Difference(inv.Remote).All() {
+			lifetimes[snap.ID()].RemoteFrom = now
+		}
+		for snap := range inv.Remote.Difference(next.Remote).All() {
+			lifetimes[snap.ID()].RemoteTo = now
+		}
+		inv = next
+
+		for _, location := range []model.Location{model.Local, model.Remote} {
+			if gap := widestGap(snapshotsAt(inv, location), now); gap.Duration() > result.MaxGap[location].Duration() {
+				result.MaxGap[location] = gap
+			}
+		}
+		if !now.Before(nextSample) {
+			result.Samples = append(result.Samples, Sample{
+				At:     now,
+				Local:  countByType(inv.Local),
+				Remote: countByType(inv.Remote),
+			})
+			nextSample = nextSample.Add(24 * time.Hour)
+		}
+	}
+
+	result.Final = inv
+	return result, nil
+}
+
+func snapshotsAt(inv *model.SnapshotInventory, location model.Location) *model.Snapshots {
+	if location == model.Local {
+		return inv.Local
+	}
+	return inv.Remote
+}
+
+func widestGap(snaps *model.Snapshots, at time.Time) Gap {
+	var widest Gap
+	var prev *model.Snapshot
+	for snap := range snaps.All() {
+		if prev != nil && snap.Time().Sub(prev.Time()) > widest.Duration() {
+			widest = Gap{From: prev, To: snap, At: at}
+		}
+		prev = snap
+	}
+	return widest
+}
+
+func countByType(snaps *model.Snapshots) map[string]int {
+	counts := map[string]int{}
+	for snap := range snaps.All() {
+		counts[snap.Type()]++
+	}
+	return counts
+}
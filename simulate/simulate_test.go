@@ -0,0 +1,122 @@
+package simulate
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"monks.co/backupd/model"
+)
+
+func TestMain(m *testing.M) {
+	// Goal calculation logs every snapshot it keeps.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestParseSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.Parse(time.DateTime, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	for _, tt := range []struct {
+		schedule string
+		at       string
+		matches  bool
+	}{
+		{"hourly=0 * * * *", "2025-03-04 05:00:00", true},
+		{"hourly=0 * * * *", "2025-03-04 05:01:00", false},
+		{"frequent=*/15 * * * *", "2025-03-04 05:45:00", true},
+		{"frequent=*/15 * * * *", "2025-03-04 05:50:00", false},
+		{"weekly=0 0 * * 0", "2025-03-02 00:00:00", true}, // a Sunday
+		{"weekly=0 0 * * 7", "2025-03-02 00:00:00", true},
+		{"weekly=0 0 * * 0", "2025-03-03 00:00:00", false},
+		{"workday=30 9 * * 1-5", "2025-03-03 09:30:00", true},
+		{"workday=30 9 * * 1-5", "2025-03-08 09:30:00", false},
+		// Restricting both days matches either.
+		{"odd=0 0 1 * 1", "2025-03-03 00:00:00", true},
+		{"odd=0 0 1 * 1", "2025-03-01 00:00:00", true},
+		{"odd=0 0 1 * 1", "2025-03-04 00:00:00", false},
+	} {
+		sched, err := ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.schedule, err)
+		}
+		if got := sched.Matches(at(tt.at)); got != tt.matches {
+			t.Errorf("%s at %s: got %v, expected %v", tt.schedule, tt.at, got, tt.matches)
+		}
+	}
+
+	for _, bad := range []string{"0 0 * * *", "daily=0 0 * *", "daily=60 0 * * *", "daily=0 0 * * 1-8", "daily=*/0 0 * * *", "my-daily=0 0 * * *"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var schedules []Schedule
+	for _, s := range []string{"hourly=0 * * * *", "daily=0 0 * * *"} {
+		sched, err := ParseSchedule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedules = append(schedules, sched)
+	}
+
+	result, err := Run(Options{
+		Start:        time.Date(2025, 1, 1, 0, 30, 0, 0, time.UTC),
+		Duration:     60 * 24 * time.Hour,
+		Schedules:    schedules,
+		LocalPolicy:  model.Policy{Counts: map[string]int{"hourly": 24, "daily": 7}},
+		RemotePolicy: model.Policy{Counts: map[string]int{"daily": 30}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(snaps *model.Snapshots, typ string) int {
+		var n int
+		for snap := range snaps.All() {
+			if snap.Type() == typ {
+				n++
+			}
+		}
+		return n
+	}
+	// Besides the policy's snapshots, the goal keeps its anchors: the
+	// oldest local snapshot, and the earliest one shared with the remote.
+	if n := count(result.Final.Local, "hourly"); n != 24+1 {
+		t.Errorf("local hourlies: got %d, expected 24 and the oldest", n)
+	}
+	if n := count(result.Final.Local, "daily"); n != 7+1 {
+		t.Errorf("local dailies: got %d, expected 7 and the earliest shared", n)
+	}
+	if n := count(result.Final.Remote, "daily"); n != 30+1 {
+		t.Errorf("remote dailies: got %d, expected 30 and the earliest shared", n)
+	}
+	if len(result.Samples) != 61 {
+		t.Errorf("samples: got %d, expected 61", len(result.Samples))
+	}
+
+	// So the remote's widest gap is from its first snapshot to the oldest
+	// of its 30 dailies.
+	gap := result.MaxGap[model.Remote]
+	if gap.From == nil || gap.From.Name != "daily-2025-01-02-00:00:01" || gap.To.Name != "daily-2025-02-01-00:00:01" {
+		t.Errorf("remote max gap: got %+v", gap)
+	}
+
+	var text strings.Builder
+	if err := result.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "max gap between retained snapshots") {
+		t.Errorf("text report is missing the max gap:\n%s", text.String())
+	}
+}
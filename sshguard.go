@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+
+	"monks.co/backupd/guard"
+)
+
+// sshGuard runs as an authorized_keys forced command on the remote, or on
+// the local host in pull mode. It
+// checks SSH_ORIGINAL_COMMAND against the commands backupd issues and, if
+// it's allowed, replaces itself with it. Every request is logged.
+func sshGuard(args []string) error {
+	fs := flag.NewFlagSet("ssh-guard", flag.ContinueOnError)
+	root := fs.String("root", "", "root dataset that commands are confined to")
+	helper := fs.String("helper", "backupd", "path of the `backupd receive` helper, as configured in helper_path")
+	logfile := fs.String("log", "/var/log/backupd-ssh-guard.log", "file to log requests to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *root == "" || fs.NArg() != 0 {
+		return errors.New("usage: backupd ssh-guard -root <root> [-helper <path>] [-log <file>]")
+	}
+
+	// Stdout and stderr belong to the ssh client, which parses them, so
+	// requests are only logged to the file.
+	logs := &lumberjack.Logger{
+		Filename:   *logfile,
+		MaxSize:    15,
+		MaxBackups: 3,
+		MaxAge:     28,
+	}
+	defer logs.Close()
+	log.SetOutput(logs)
+
+	command := os.Getenv("SSH_ORIGINAL_COMMAND")
+	client := os.Getenv("SSH_CLIENT")
+
+	g := &guard.Guard{
+		Root:         strings.TrimSuffix(*root, "/"),
+		Helper:       *helper,
+		ResolveToken: resolveToken,
+	}
+	argv, err := g.Check(command)
+	if err != nil {
+		log.Printf("refused [%s] %q: %v", client, command, err)
+		return err
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		log.Printf("refused [%s] %q: %v", client, command, err)
+		return err
+	}
+	log.Printf("allowed [%s] %q", client, command)
+
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// resolveToken returns the snapshot that a resume token sends.
+func resolveToken(token string) (string, error) {
+	out, err := exec.Command("zfs", "send", "-nv", "-t", token).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("zfs send -nv -t: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return guard.ParseTokenName(string(out))
+}
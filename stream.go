@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"monks.co/backupd/events"
+	"monks.co/backupd/logger"
+	"monks.co/backupd/model"
+)
+
+// keepaliveInterval is how often an idle event stream is written to, so
+// that proxies don't time it out.
+const keepaliveInterval = 30 * time.Second
+
+// datasetEvent is the data of a DatasetUpdated event: the dataset's
+// summary, as at /api/v1/datasets, or just its name if it's gone. HTML is
+// set if anyone is following the web UI.
+type datasetEvent struct {
+	apiDataset
+	Removed bool         `json:"removed,omitempty"`
+	HTML    *datasetHTML `json:"html,omitempty"`
+}
+
+// datasetHTML is a dataset's parts of the web UI: its sidebar link, its row
+// in the overview table, and the body of its page.
+type datasetHTML struct {
+	Link string `json:"link"`
+	Row  string `json:"row"`
+	View string `json:"view"`
+}
+
+// stepEvent is the data of a StepStatusChanged event. HTML is the step's
+// row in the web UI's plan table, and Row is the dataset's row in the
+// overview table, which shows the active transfer's progress.
+type stepEvent struct {
+	Dataset string  `json:"dataset"`
+	Step    apiStep `json:"step"`
+	HTML    string  `json:"html"`
+	Row     string  `json:"row"`
+}
+
+// logEvent is the data of a LogAppended event. Dataset is empty for the
+// global log, and Step is set for a plan step's log.
+type logEvent struct {
+	Dataset string `json:"dataset,omitempty"`
+	Step    *int   `json:"step,omitempty"`
+	logger.LogEntry
+}
+
+// published tracks what subscribers have been told: which datasets exist,
+// so that a dataset that's gone can be published as removed, and the
+// signature of the rest of the state.
+type published struct {
+	mu       gosync.Mutex
+	datasets map[model.DatasetName]bool
+	state    string
+}
+
+// stateSignature sums up the parts of the state that aren't datasets, so
+// that a change to any of them can be published as StateChanged.
+func stateSignature(state *model.Model) string {
+	var sig strings.Builder
+	if target := state.Target; target != nil {
+		fmt.Fprintf(&sig, "target %t %s;", target.Present, target.Current)
+		for _, drive := range target.ListDrives() {
+			fmt.Fprintf(&sig, "drive %s %d;", drive.GUID, drive.LastSynced.Unix())
+		}
+	}
+	fmt.Fprintf(&sig, "warnings %d;", len(state.Warnings))
+	for _, restore := range state.Restores {
+		fmt.Fprintf(&sig, "restore %d %d", restore.ID, restore.Status)
+		if restore.Plan != nil {
+			for _, step := range restore.Plan.Steps {
+				fmt.Fprintf(&sig, " %d", step.Status)
+				if step.Progress != nil {
+					fmt.Fprintf(&sig, "/%d", step.Progress.BytesDone)
+				}
+			}
+		}
+		sig.WriteByte(';')
+	}
+	return sig.String()
+}
+
+// publishState publishes a StateChanged event if anything but the datasets
+// changed since the last call.
+func (b *Backupd) publishState() {
+	state := b.state.Deref()
+	if state == nil {
+		return
+	}
+
+	b.published.mu.Lock()
+	defer b.published.mu.Unlock()
+	if sig := stateSignature(state); sig != b.published.state {
+		b.published.state = sig
+		b.events.Publish(events.Event{Type: events.StateChanged, Data: struct{}{}})
+	}
+}
+
+// publishDataset publishes a DatasetUpdated event for the dataset, or one
+// marking it removed if it's gone.
+func (b *Backupd) publishDataset(name model.DatasetName) {
+	state := b.state.Deref()
+	if state == nil {
+		return
+	}
+
+	b.published.mu.Lock()
+	defer b.published.mu.Unlock()
+	b.publishDatasetLocked(name, state.GetDataset(name))
+}
+
+// publishDatasets publishes a DatasetUpdated event for every dataset, and
+// marks removed those that are gone, after a change that may touch any of
+// them, such as a refresh.
+func (b *Backupd) publishDatasets() {
+	state := b.state.Deref()
+	if state == nil {
+		return
+	}
+
+	b.published.mu.Lock()
+	defer b.published.mu.Unlock()
+	for _, name := range state.ListDatasets() {
+		b.publishDatasetLocked(name, state.GetDataset(name))
+	}
+	for name := range b.published.datasets {
+		if state.GetDataset(name) == nil {
+			b.publishDatasetLocked(name, nil)
+		}
+	}
+}
+
+func (b *Backupd) publishDatasetLocked(name model.DatasetName, ds *model.Dataset) {
+	if b.published.datasets == nil {
+		b.published.datasets = map[model.DatasetName]bool{}
+	}
+	if ds == nil {
+		if !b.published.datasets[name] {
+			return
+		}
+		delete(b.published.datasets, name)
+		b.events.Publish(events.Event{Type: events.DatasetUpdated, Data: datasetEvent{apiDataset: apiDataset{Name: name.String()}, Removed: true}})
+		return
+	}
+	b.published.datasets[name] = true
+
+	event := datasetEvent{apiDataset: b.apiDataset(name, ds)}
+	if b.events.Subscribers() > 0 {
+		event.HTML = &datasetHTML{
+			Link: b.renderFragment(renderDatasetLink(name, ds, b.syncStatus, "")),
+			Row:  b.renderFragment(renderDatasetRow(name, ds, b.syncStatus)),
+			View: b.renderFragment(renderDatasetView(ds)),
+		}
+	}
+	b.events.Publish(events.Event{Type: events.DatasetUpdated, Data: event})
+}
+
+// renderFragment renders part of the web UI to be sent in an event.
+func (b *Backupd) renderFragment(component templ.Component) string {
+	var html bytes.Buffer
+	if err := component.Render(context.Background(), &html); err != nil {
+		b.globalLogs.Printf("rendering event: %v", err)
+	}
+	return html.String()
+}
+
+// publishStep publishes a StepStatusChanged event for a dataset's plan step.
+func (b *Backupd) publishStep(dataset model.DatasetName, index int) {
+	if b.events.Subscribers() == 0 {
+		return
+	}
+	ds := b.state.Deref().GetDataset(dataset)
+	if ds == nil || ds.Plan == nil || index >= len(ds.Plan.Steps) {
+		return
+	}
+	step := ds.Plan.Steps[index]
+
+	b.events.Publish(events.Event{Type: events.StepStatusChanged, Data: stepEvent{
+		Dataset: dataset.String(),
+		Step:    newAPIStep(index, step),
+		HTML:    b.renderFragment(renderStepRow(index, step)),
+		Row:     b.renderFragment(renderDatasetRow(dataset, ds, b.syncStatus)),
+	}})
+}
+
+// setSyncing marks the dataset as syncing, or not, and publishes the change.
+func (b *Backupd) setSyncing(dataset model.DatasetName, syncing bool) {
+	b.syncStatus.SetSyncing(dataset, syncing)
+	b.notifyDatasetChange(dataset)
+}
+
+// logLine is a line written to some logger, waiting to be published.
+type logLine struct {
+	logger *logger.Logger
+	entry  logger.LogEntry
+}
+
+// publishLogs publishes lines written to the global log, and to datasets'
+// and plan steps' logs, as LogAppended events. Lines are queued by the
+// writing goroutine, which may be holding the state, and resolved to their
+// dataset here. Lines written faster than they can be published are
+// dropped.
+func (b *Backupd) publishLogs(ctx context.Context) {
+	lines := make(chan logLine, events.Buffer)
+	logger.Observe(func(l *logger.Logger, entry logger.LogEntry) {
+		select {
+		case lines <- logLine{l, entry}:
+		default:
+		}
+	})
+	defer logger.Observe(nil)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-lines:
+			if b.events.Subscribers() == 0 {
+				continue
+			}
+			if event, ok := b.resolveLog(line); ok {
+				b.events.Publish(events.Event{Type: events.LogAppended, Data: event})
+			}
+		}
+	}
+}
+
+// resolveLog finds which log the line was written to: the global log, a
+// dataset's, or a plan step's. Other loggers, such as restores', aren't
+// published.
+func (b *Backupd) resolveLog(line logLine) (logEvent, bool) {
+	event := logEvent{LogEntry: line.entry}
+	if line.logger == b.globalLogs {
+		return event, true
+	}
+	state := b.state.Deref()
+	if state == nil {
+		return event, false
+	}
+	for name, ds := range state.Datasets {
+		if ds.Logs == line.logger {
+			event.Dataset = name.String()
+			return event, true
+		}
+		if ds.Plan == nil {
+			continue
+		}
+		for i, step := range ds.Plan.Steps {
+			if step.Logs == line.logger {
+				event.Dataset, event.Step = name.String(), &i
+				return event, true
+			}
+		}
+	}
+	return event, false
+}
+
+// serveEvents streams events to the client as Server-Sent Events, until it
+// disconnects or falls too far behind. Each event's data is JSON.
+func (b *Backupd) serveEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, unsubscribe := b.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				b.globalLogs.Printf("encoding %s event: %v", event.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"monks.co/backupd/events"
+	"monks.co/backupd/model"
+)
+
+func TestDatasetEvents(t *testing.T) {
+	b := testAPI(t)
+	ch, unsubscribe := b.events.Subscribe()
+	defer unsubscribe()
+
+	// Progress is published as a step event alone.
+	b.updateStep("/home", 0, func(step *model.PlanStep) {
+		step.Progress = &model.TransferProgress{BytesDone: 1}
+	})
+	if event := <-ch; event.Type != events.StepStatusChanged {
+		t.Fatalf("expected a step event, got %+v", event)
+	}
+	select {
+	case event := <-ch:
+		t.Fatalf("expected no more events, got %+v", event)
+	default:
+	}
+
+	b.notifyDatasetChange("/home")
+	event := <-ch
+	data, ok := event.Data.(datasetEvent)
+	if event.Type != events.DatasetUpdated || !ok || data.Name != "/home" || data.HTML == nil {
+		t.Fatalf("expected a dataset event with its HTML, got %+v", event)
+	}
+	if !strings.Contains(data.HTML.View, `data-dataset="/home"`) {
+		t.Errorf("expected the dataset's view, got %q", data.HTML.View)
+	}
+
+	b.state.Reset(model.New())
+	b.notifyDatasetsChange()
+	if event := <-ch; !event.Data.(datasetEvent).Removed {
+		t.Errorf("expected /home to be removed, got %+v", event)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"monks.co/backupd/env"
+	"monks.co/backupd/model"
+)
+
+// targetPollInterval is how often backupd looks for a removable target
+// while it's away.
+const targetPollInterval = 5 * time.Minute
+
+// checkTarget records whether the removable target is attached, importing
+// it first if configured to. It reports whether the remote can be synced,
+// which is always true for an ordinary remote.
+func (b *Backupd) checkTarget(ctx context.Context) bool {
+	pool := b.config.Remote.RemovablePool
+	if pool == "" {
+		return true
+	}
+	logs := b.globalLogs
+
+	target := b.state.Deref().Target.Clone()
+	if target == nil {
+		target = model.NewTarget(pool)
+	}
+
+	info, err := b.findPool()
+	if err != nil {
+		logs.Printf("looking for removable pool '%s': %s", pool, err)
+	}
+	if info == nil && b.config.Remote.AutoImport && ctx.Err() == nil {
+		if err := b.env.Remote.ImportPool(logs, pool); err != nil {
+			logs.Printf("removable pool '%s' is not attached: %s", pool, err)
+		} else if info, err = b.findPool(); err != nil {
+			logs.Printf("looking for removable pool '%s' after import: %s", pool, err)
+		} else if info != nil {
+			logs.Printf("imported removable pool '%s' (%s)", info.Name, info.GUID)
+			target.Imported = true
+		}
+	}
+
+	target.Present = info != nil
+	if info != nil {
+		drive := target.Drives[info.GUID]
+		if drive == nil {
+			drive = &model.TargetDrive{GUID: info.GUID}
+			target.Drives[info.GUID] = drive
+		}
+		drive.Name = info.Name
+		drive.LastSeen = time.Now()
+		target.Current = info.GUID
+	} else {
+		target.Imported = false
+	}
+
+	b.setTarget(target)
+	return target.Present
+}
+
+// findPool returns the imported pool matching the removable target by name
+// or GUID, or nil if there is none.
+func (b *Backupd) findPool() (*env.PoolInfo, error) {
+	pool := b.config.Remote.RemovablePool
+	pools, err := b.env.Remote.ListPools(b.globalLogs)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range pools {
+		if info.Name == pool || info.GUID == pool {
+			return &info, nil
+		}
+	}
+	return nil, nil
+}
+
+// finishTarget records the attached drive's inventory after a sync cycle,
+// for planning while it's away, and exports the pool if backupd imported
+// it.
+func (b *Backupd) finishTarget(synced bool) {
+	state := b.state.Deref()
+	if state.Target == nil || !state.Target.Present {
+		return
+	}
+
+	target := state.Target.Clone()
+	drive := target.CurrentDrive()
+	drive.Inventory = map[model.DatasetName][]*model.Snapshot{}
+	for name, ds := range state.Datasets {
+		if ds.Current != nil && ds.Current.Remote.Len() > 0 {
+			drive.Inventory[name] = slices.Collect(ds.Current.Remote.All())
+		}
+	}
+	if synced {
+		drive.LastSynced = time.Now()
+	}
+
+	if target.Imported {
+		if err := b.env.Remote.ExportPool(b.globalLogs, drive.Name); err != nil {
+			b.globalLogs.Printf("exporting removable pool '%s': %s", drive.Name, err)
+		} else {
+			b.globalLogs.Printf("exported removable pool '%s'", drive.Name)
+			target.Present = false
+			target.Imported = false
+		}
+	}
+
+	b.setTarget(target)
+}
+
+// setTarget records the removable target in the state, and saves it so
+// that the bases kept for drives that are away survive a restart.
+func (b *Backupd) setTarget(target *model.Target) {
+	b.state.Swap(model.SetTarget(target))
+	b.notifyStateChange()
+	if b.history == nil {
+		return
+	}
+	if err := b.history.SaveTarget(target); err != nil {
+		b.globalLogs.Printf("%v", err)
+	}
+}
+
+// loadTarget restores the removable target saved by a previous process,
+// before the first plan is calculated, so that drives which are away keep
+// their bases. A target saved for a different pool is ignored.
+func (b *Backupd) loadTarget() error {
+	pool := b.config.Remote.RemovablePool
+	if pool == "" {
+		return nil
+	}
+	target, err := b.history.LoadTarget()
+	if err != nil {
+		return err
+	}
+	if target == nil || target.Pool != pool {
+		return nil
+	}
+	// Whether it's attached is checked afresh each cycle.
+	target.Present = false
+	b.state.Swap(model.SetTarget(target))
+	return nil
+}
+
+// lastKnownRemote fills in the remote side of the model from the last-known
+// inventory of the removable target while it's away.
+func (b *Backupd) lastKnownRemote() {
+	drive := b.state.Deref().Target.CurrentDrive()
+	if drive == nil {
+		return
+	}
+	for name, snapshots := range drive.Inventory {
+		b.state.Swap(model.AddRemoteDataset(name, snapshots, nil))
+	}
+}
+
+// keepAwayDriveBases keeps a base in the target for each removable drive
+// that's away, so that it can be brought up to date incrementally when it
+// comes back.
+func (b *Backupd) keepAwayDriveBases(dataset model.DatasetName, current, target *model.SnapshotInventory) {
+	for _, snap := range b.state.Deref().Target.Protected(dataset) {
+		if current.Local.Has(snap) {
+			target.Keep(model.Local, snap, "base for a removable drive that's away")
+		}
+	}
+}
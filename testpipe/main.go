@@ -12,7 +12,7 @@ func main() {
 	ls := exec.Command("fish", "-c", "while true ; echo hello world ; sleep 0.1 ; end")
 	// ls := exec.Command("cat", "/var/log/backupd.log")
 	wc := exec.Command("awk", "{ print $1 }")
-	if err := env.Pipe(context.Background(), logger.New("pipetest"), 0, ls, wc); err != nil {
+	if _, err := env.Pipe(context.Background(), logger.New("pipetest"), 0, ls, wc, nil); err != nil {
 		panic(err)
 	}
 }